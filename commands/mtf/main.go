@@ -2,20 +2,28 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	fib "github.com/spaskalev/misc/encoding/fibonacci"
 	mtf "github.com/spaskalev/misc/encoding/mtf"
 	iou "github.com/spaskalev/misc/ioutil"
 	"io"
 	"os"
+	"time"
 )
 
 func main() {
 	d := flag.Bool("d", false, "Toggle decode mode.")
+	p := flag.Bool("p", false, "Print the progress, ratio and throughput to stderr.")
 	flag.Parse()
 
 	var (
-		input  io.Reader = iou.SizedReader(os.Stdin, 4096)
-		output io.Writer = iou.SizedWriter(os.Stdout, 4096)
+		in     *iou.CountingReader = iou.NewCountingReader(os.Stdin)
+		out    *iou.CountingWriter = iou.NewCountingWriter(os.Stdout)
+		start  time.Time           = time.Now()
+		reader io.ReadCloser       = iou.ReadAhead(in, 4, 4096)
+		input  io.Reader           = reader
+		buffer io.WriteCloser      = iou.WriteBehind(out, 4, 4096)
+		output io.Writer           = buffer
 		code   int
 	)
 
@@ -24,21 +32,47 @@ func main() {
 		os.Exit(code)
 	}()
 
-	// Flush the output buffer
-	defer output.Write(nil)
+	// Report the progress once the output is flushed
+	report := func(int64) {
+		var ratio, rate float64
+		if in.Count() > 0 {
+			ratio = float64(out.Count()) / float64(in.Count())
+		}
+		if seconds := time.Since(start).Seconds(); seconds > 0 {
+			rate = float64(in.Count()) / seconds / (1 << 20)
+		}
+		fmt.Fprintf(os.Stderr, "%d in, %d out, ratio %.3f, %.2f MiB/s\n", in.Count(), out.Count(), ratio, rate)
+	}
+	if *p {
+		input = iou.ProgressReader(input, time.Second, report)
+		defer report(0)
+	}
 
-	if *d {
-		input = mtf.Decoder(fib.Decoder(input))
-	} else {
-		input = mtf.Encoder(input)
+	// Flush the output buffer and stop reading
+	defer func() {
+		// A failed transform already reported the sticky error of the buffer
+		if err := buffer.Close(); err != nil && code == 0 {
+			os.Stderr.WriteString("Error while flushing output buffer.\n" + err.Error() + "\n")
+			code = 1
+		}
+	}()
+	defer reader.Close()
 
-		// Encode output as fibonacci integers
-		output = fib.Encoder(output)
-		defer output.Write(nil)
+	// Encode the mtf output as fibonacci integers
+	var pipeline iou.Transform = iou.Pipeline(mtf.EncodeTransform, fib.EncodeTransform)
+	if *d {
+		pipeline = iou.Pipeline(fib.DecodeTransform, mtf.DecodeTransform)
 	}
 
-	if _, err := io.Copy(output, input); err != nil {
-		os.Stderr.WriteString("Error while transforming data.\n" + err.Error())
+	writer, err := pipeline.NewWriter(output)
+	if err == nil {
+		_, err = io.Copy(writer, input)
+		if cerr := writer.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if err != nil {
+		os.Stderr.WriteString("Error while transforming data.\n" + err.Error() + "\n")
 		code = 1
 	}
 }
@@ -1,71 +1,215 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	iou "github.com/spaskalev/misc/ioutil"
 	predictor "github.com/spaskalev/misc/predictor"
 	"io"
+	"io/ioutil"
 	"os"
+	"time"
 )
 
 func main() {
-	var code int
+	d := flag.Bool("d", false, "Toggle decompress mode.")
+	r := flag.Bool("r", false, "Use the bare RFC1978 format instead of the framed one.")
+	c := flag.Int("c", 0, "Compress in independent chunks of the given size, for concurrency.")
+	j := flag.Int("j", 0, "The number of chunks to process concurrently, defaults to the number of CPUs.")
+	s := flag.Bool("s", false, "Append a chunk index for random access, requires -c.")
+	v := flag.Bool("v", false, "Print compression statistics to stderr.")
+	D := flag.String("D", "", "Seed the guess table from the given dictionary file.")
+	t := flag.Bool("t", false, "Train a dictionary from the input instead of compressing it.")
+	m := flag.Int("m", 0, "The number of candidate guesses per guess table slot, 1, 2 or 4.")
+	p := flag.Bool("p", false, "Print the progress, ratio and throughput to stderr.")
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stdout, "Usage: pdc [-d] [-r] [-c size [-s]] [-j workers] [-m candidates] [-v] [-p] [-D dictionary]")
+		fmt.Fprintln(os.Stdout, "       pdc -t [-m candidates]")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	var (
+		code   int
+		input  io.Reader         = os.Stdin
+		output io.Writer         = os.Stdout
+		opts   predictor.Options = predictor.Options{ChunkSize: *c, Workers: *j, Seekable: *s, Candidates: *m}
+	)
+	if *D != "" {
+		dict, err := dictionary(*D)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error while reading the dictionary.\n", err)
+			os.Exit(1)
+		}
+		opts.TableBits, opts.Hash, opts.Candidates, opts.Dictionary = dict.Options().TableBits, dict.Options().Hash, dict.Options().Candidates, dict
+	}
+
+	var report func()
+	if *p {
+		var (
+			in    *iou.CountingReader = iou.NewCountingReader(input)
+			out   *iou.CountingWriter = iou.NewCountingWriter(output)
+			start time.Time           = time.Now()
+		)
+		report = func() { printProgress(os.Stderr, in.Count(), out.Count(), time.Since(start)) }
+		input = iou.ProgressReader(in, time.Second, func(int64) { report() })
+		output = out
+	}
+
 	switch {
-	case len(os.Args) == 1:
-		code = compress(os.Stdout, os.Stdin)
-	case len(os.Args) == 2 && os.Args[1] == "-d":
-		code = decompress(os.Stdout, os.Stdin)
+	case flag.NArg() > 0:
+		flag.Usage()
+	case *t:
+		code = train(output, input, opts)
+	case *d:
+		code = decompress(output, input, *r, opts)
 	default:
-		fmt.Fprintln(os.Stdout, "Usage: pdc [-d]")
+		code = compress(output, input, *r, *v, opts)
+	}
+
+	if report != nil {
+		report()
 	}
 	os.Exit(code)
 }
 
+// Prints the transferred bytes, their ratio and the input throughput to the given io.Writer
+func printProgress(output io.Writer, in, out int64, elapsed time.Duration) {
+	var ratio, rate float64
+	if in > 0 {
+		ratio = float64(out) / float64(in)
+	}
+	if seconds := elapsed.Seconds(); seconds > 0 {
+		rate = float64(in) / seconds / (1 << 20)
+	}
+	fmt.Fprintf(output, "%d in, %d out, ratio %.3f, %.2f MiB/s\n", in, out, ratio, rate)
+}
+
 // Compress the data from the given io.Reader and write it to the given io.Writer
-// I/O is buffered for better performance
-func compress(output io.Writer, input io.Reader) int {
+// I/O is buffered and done on separate goroutines for better performance
+func compress(output io.Writer, input io.Reader, raw bool, verbose bool, opts predictor.Options) int {
 	var (
 		err        error
-		buffer     io.Writer = iou.SizedWriter(output, 4096)
-		compressor io.Writer = predictor.Compressor(buffer)
+		reader     io.ReadCloser  = iou.ReadAhead(input, 4, 4096)
+		buffer     io.WriteCloser = iou.WriteBehind(output, 4, 4096)
+		compressor interface {
+			io.WriteCloser
+			Stats() predictor.Stats
+		}
 	)
 
-	_, err = io.Copy(compressor, input)
+	if raw {
+		compressor, err = predictor.NewWriterOptions(buffer, opts)
+	} else {
+		compressor, err = predictor.NewFrameWriterOptions(buffer, opts)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error while creating the compressor.\n", err)
+		return 1
+	}
+
+	_, err = io.Copy(compressor, reader)
+	reader.Close()
 	if err != nil {
 		fmt.Fprintln(os.Stderr, "Error while compressing.\n", err)
 		return 1
 	}
 
 	// Flush the compressor
-	_, err = compressor.Write(nil)
+	err = compressor.Close()
 	if err != nil {
 		fmt.Fprintln(os.Stderr, "Error while flushing compresssor buffer.\n", err)
 		return 1
 	}
 
 	// Flush the buffer
-	_, err = buffer.Write(nil)
+	err = buffer.Close()
 	if err != nil {
 		fmt.Fprintln(os.Stderr, "Error while flushing output buffer.\n", err)
 		return 1
 	}
 
+	if verbose {
+		printStats(os.Stderr, compressor.Stats())
+	}
+
 	return 0
 }
 
+// Prints the compressor's statistics to the given io.Writer
+func printStats(output io.Writer, stats predictor.Stats) {
+	fmt.Fprintf(output, "bytes:  %d in, %d out, ratio %.3f\n", stats.In, stats.Out, stats.Ratio())
+	fmt.Fprintf(output, "blocks: %d, %d hits, %d misses, hit rate %.3f\n", stats.Blocks, stats.Hits, stats.Misses, stats.HitRate())
+	fmt.Fprintf(output, "table:  %d of %d entries occupied\n", stats.Occupied, stats.TableSize)
+}
+
 // Decompress the data from the given io.Reader and write it to the given io.Writer
-// I/O is buffered for better performance
-func decompress(output io.Writer, input io.Reader) int {
+// I/O is buffered and done on separate goroutines for better performance
+func decompress(output io.Writer, input io.Reader, raw bool, opts predictor.Options) int {
 	var (
 		err          error
-		decompressor io.Reader = predictor.Decompressor(iou.SizedReader(input, 4096))
+		reader       io.ReadCloser  = iou.ReadAhead(input, 4, 4096)
+		buffer       io.WriteCloser = iou.WriteBehind(output, 4, 4096)
+		decompressor io.Reader
 	)
+	defer reader.Close()
 
-	_, err = io.Copy(output, decompressor)
+	if raw {
+		decompressor, err = predictor.NewReaderOptions(reader, opts)
+	} else {
+		decompressor, err = predictor.NewFrameReaderOptions(reader, opts)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error while reading the stream header.\n", err)
+		return 1
+	}
+
+	_, err = io.Copy(buffer, decompressor)
 	if err != nil {
 		fmt.Fprintln(os.Stderr, "Error while decompressing.\n", err)
 		return 1
 	}
 
+	// Flush the buffer
+	err = buffer.Close()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error while flushing output buffer.\n", err)
+		return 1
+	}
+
+	return 0
+}
+
+// Reads a dictionary from the given file
+func dictionary(name string) (*predictor.Dictionary, error) {
+	var dict predictor.Dictionary
+
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	return &dict, dict.UnmarshalBinary(data)
+}
+
+// Train a dictionary from the data of the given io.Reader and write it to the given io.Writer
+func train(output io.Writer, input io.Reader, opts predictor.Options) int {
+	sample, err := ioutil.ReadAll(input)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error while reading the sample.\n", err)
+		return 1
+	}
+
+	dict, err := predictor.TrainDictionary(opts, sample)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error while training the dictionary.\n", err)
+		return 1
+	}
+
+	data, _ := dict.MarshalBinary()
+	if _, err = output.Write(data); err != nil {
+		fmt.Fprintln(os.Stderr, "Error while writing the dictionary.\n", err)
+		return 1
+	}
+
 	return 0
 }
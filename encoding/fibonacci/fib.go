@@ -12,6 +12,8 @@
 package fibonacci // import "github.com/spaskalev/misc/encoding/fibonacci"
 
 import (
+	"errors"
+	iou "github.com/spaskalev/misc/ioutil"
 	"io"
 )
 
@@ -93,151 +95,91 @@ func (f Numbers) Decode(value uint64) (result uint64, length byte) {
 	return result + f[length] - 1, length + 1
 }
 
+// The bit length of the longest code of a byte value
+const maxCodeLength = 16
+
+// ErrCode is returned when the data does not contain a valid code
+var ErrCode = errors.New("fibonacci: invalid code")
+
 // Returns a fibonacci encoder over the provided io.Writer
+//
+// It buffers the encoded bits. A call with a nil slice flushes them,
+// padding the last byte with zeros.
 func Encoder(target io.Writer) io.Writer {
 	var enc encoder
-	enc.target = target
+	enc.writer = iou.NewBitWriter(target, iou.LSB)
 	return &enc
 }
 
 type encoder struct {
-	target    io.Writer
-	buffer    [2]byte
-	remaining byte
-	length    byte
+	writer *iou.BitWriter
 }
 
 // Implements io.Writer
 func (e *encoder) Write(input []byte) (int, error) {
-	var (
-		total int
-		err   error
-	)
-
 	// Flush on a nil slice
 	if input == nil {
-		_, err = e.target.Write([]byte{byte(e.remaining)})
-		return 0, err
+		return 0, e.writer.Flush()
 	}
 
-	for _, currentByte := range input {
-		// Get the fibonacci code and bit length for the current byte
-		enc, len := uint16(lookup[currentByte]), byte(lookup[currentByte]>>16)
-
-		// Add current bits to higher positions
-		e.remaining |= byte(enc << e.length)
-
-		// maximum length of added bits to e.remaining
-		added := 8 - e.length
-
-		// Shift the the encoded value and account for its length
-		enc >>= added
-		e.length += len
-		len -= added
-
-		// Not enough bits to write
-		if e.length < 8 {
-			// Account for the processed input byte
-			total++
-
-			continue
-		}
-
-		// Clearing e.length is not necessary as it will be overwritten later
-
-		// Stage the complete byte for writing
-		buffer := e.buffer[:1]
-		buffer[0] = byte(e.remaining)
-
-		// Stage every full byte from the encoded value for writing
-		//
-		// The bitlength of the largest encoded byte value, 255, is 13.
-		// Even with 7 bits already in the buffer this leaves [7+1], [8]
-		// and 4 bits remaining => a single if is enough instead of a for.
-		//
-		// 128 is [1000 0000] in binary. Any value equal or greater than it
-		// will be atleast 8 bits in length
-		if enc >= 128 {
-			buffer = append(buffer, byte(enc))
-			enc >>= 8
-			len -= 8
+	for i, currentByte := range input {
+		// Write the fibonacci code of the current byte with its bit length
+		if err := e.writer.WriteBits(uint64(uint16(lookup[currentByte])), uint(lookup[currentByte]>>16)); err != nil {
+			return i, err
 		}
-
-		// Store the remaining bits
-		e.remaining, e.length = byte(enc), len
-
-		// Write the staged bytes
-		_, err = e.target.Write(buffer)
-
-		// Abort write on error
-		if err != nil {
-			break
-		}
-
-		// Account for the processed input byte
-		total++
 	}
-	return total, err
+	return len(input), nil
+}
+
+// Flushes the encoded bits. It does not close the underlying writer.
+func (e *encoder) Close() error {
+	return e.writer.Flush()
 }
 
 // Returns a fibonacci decoder over the provided io.Reader
 func Decoder(source io.Reader) io.Reader {
 	var dec decoder
-	dec.source = source
+	dec.reader = iou.NewBitReader(source, iou.LSB)
 	return &dec
 }
 
 type decoder struct {
-	source io.Reader
-	buffer uint64
-	at     byte
+	reader *iou.BitReader
 }
 
 // Implements io.Reader
 func (d *decoder) Read(output []byte) (int, error) {
-	var (
-		total int
-		err   error
-	)
-
-start:
-	// While we have suitable buffered data and enough output space
-	for (len(output) > 0) && ((d.buffer & (d.buffer >> 1)) > 0) {
-		val, len := codec.Decode(d.buffer)
-
-		// Store the decoded byte
-		output[0] = byte(val)
-
-		// Advance the internal and output buffers
-		output = output[1:]
-		d.buffer >>= len
-		d.at -= len
-
-		// Account for the processed output byte
-		total++
-	}
-
-	// Termination condition
-	if len(output) == 0 || err != nil {
-		return total, err
-	}
-
-	// We need to limit the output's size else we could end up with a lot of small values
-	// that fit neither in the output slice nor in the internal buffer
-	//
-	// (63 is [0011 1111] in binary, xor is a substraction and right shift a division)
-	free := int((63 ^ d.at) >> 3)
-	if free > len(output) {
-		free = len(output)
-	}
+	for total := range output {
+		bits, err := d.reader.PeekBits(maxCodeLength)
+
+		// A code ends with the first pair of raised bits
+		if bits&(bits>>1) == 0 {
+			switch {
+			case err == nil:
+				err = ErrCode
+			case err == io.ErrUnexpectedEOF && bits == 0:
+				// The zero padding of the last byte
+				err = io.EOF
+			}
+			if total > 0 && err == io.EOF {
+				err = nil
+			}
+			return total, err
+		}
 
-	// Read data and transfer to the internal buffer
-	count, err := d.source.Read(output[:free])
-	for _, v := range output[:count] {
-		d.buffer |= uint64(v) << d.at
-		d.at += 8
+		value, length := codec.Decode(bits)
+		d.reader.ReadBits(uint(length))
+		output[total] = byte(value)
 	}
-
-	// To ensure a tail call :)
-	goto start
+	return len(output), nil
 }
+
+// The fibonacci encoding and decoding as ioutil transforms
+var (
+	EncodeTransform iou.Transform = iou.WriterTransform(func(writer io.Writer) (io.WriteCloser, error) {
+		return &encoder{iou.NewBitWriter(writer, iou.LSB)}, nil
+	})
+	DecodeTransform iou.Transform = iou.ReaderTransform(func(reader io.Reader) (io.Reader, error) {
+		return Decoder(reader), nil
+	})
+)
@@ -2,10 +2,14 @@ package fibonacci // import "github.com/spaskalev/misc/encoding/fibonacci"
 
 import (
 	"bytes"
+	"errors"
 	diff "github.com/spaskalev/diff"
+	iot "github.com/spaskalev/misc/ioutil/iotest"
 	"io"
+	"io/ioutil"
 	"strings"
 	"testing"
+	"testing/iotest"
 )
 
 func TestNumbers(t *testing.T) {
@@ -98,6 +102,109 @@ func TestWriterReader(t *testing.T) {
 	}
 }
 
+// Encodes every byte value and returns the input and the encoded data
+func encoded(t *testing.T) ([]byte, []byte) {
+	var (
+		buf   bytes.Buffer
+		w     io.Writer = Encoder(&buf)
+		input []byte    = make([]byte, 256)
+	)
+
+	for i := range input {
+		input[i] = byte(i)
+	}
+	if _, err := w.Write(input); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(nil); err != nil {
+		t.Fatal(err)
+	}
+	return input, buf.Bytes()
+}
+
+func TestReaderWrappers(t *testing.T) {
+	input, data := encoded(t)
+
+	for _, wrapper := range iot.Readers {
+		result, err := ioutil.ReadAll(Decoder(wrapper.Wrap(bytes.NewReader(data))))
+		if err != nil && err != iotest.ErrTimeout {
+			t.Error(wrapper.Name, "unexpected error", err)
+		}
+		if !bytes.HasPrefix(input, result) || (err == nil && len(result) != len(input)) {
+			t.Error(wrapper.Name, "unexpected result", result)
+		}
+	}
+}
+
+func TestWriterFaults(t *testing.T) {
+	var fail error = errors.New("Invalid write")
+
+	input, data := encoded(t)
+	for limit := 0; limit < len(data); limit++ {
+		var (
+			buf bytes.Buffer
+			w   io.Writer = Encoder(iot.ErrorWriter(&buf, limit, fail))
+		)
+
+		_, err := w.Write(input)
+		if err == nil {
+			_, err = w.Write(nil)
+		}
+		if err != fail {
+			t.Error("Unexpected error for limit", limit, err)
+		}
+		if !bytes.Equal(buf.Bytes(), data[:limit]) {
+			t.Error("Unexpected output for limit", limit)
+		}
+	}
+
+	// Short writes are reported as such
+	var buf bytes.Buffer
+	w := Encoder(iot.ShortWriter(&buf, 1))
+	_, err := w.Write(input)
+	if err == nil {
+		_, err = w.Write(nil)
+	}
+	if err != io.ErrShortWrite {
+		t.Error("Unexpected error for a short write", err)
+	}
+}
+
+func TestDecoderErrors(t *testing.T) {
+	input, data := encoded(t)
+
+	// A stream cut inside a code is truncated
+	result, err := ioutil.ReadAll(Decoder(bytes.NewReader(data[:len(data)/2])))
+	if err != io.ErrUnexpectedEOF || !bytes.HasPrefix(input, result) {
+		t.Error("Unexpected result for a truncated stream", len(result), err)
+	}
+
+	// Bits with no terminating pair are invalid
+	result, err = ioutil.ReadAll(Decoder(bytes.NewReader([]byte{0, 0, 0xff})))
+	if err != ErrCode || len(result) != 0 {
+		t.Error("Unexpected result for an invalid code", result, err)
+	}
+}
+
+func BenchmarkDecoder(b *testing.B) {
+	var (
+		buf   bytes.Buffer
+		w     io.Writer = Encoder(&buf)
+		input []byte    = make([]byte, 1<<16)
+	)
+
+	for i := range input {
+		input[i] = byte(i * i >> 7)
+	}
+	w.Write(input)
+	w.Write(nil)
+
+	b.SetBytes(int64(len(input)))
+	for i := 0; i < b.N; i++ {
+		io.Copy(ioutil.Discard, Decoder(bytes.NewReader(buf.Bytes())))
+	}
+}
+
 func u2s(b uint64, l byte) (result string) {
 	for i := byte(0); i < l; i++ {
 		if (b & 1) > 0 {
@@ -109,3 +216,26 @@ func u2s(b uint64, l byte) (result string) {
 	}
 	return
 }
+
+func TestTransforms(t *testing.T) {
+	input, expected := encoded(t)
+
+	// The encoder is native on the writing side and the decoder on the reading side
+	reader, err := EncodeTransform.NewReader(bytes.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result, err := ioutil.ReadAll(reader); err != nil || !bytes.Equal(result, expected) {
+		t.Error("Unexpected encoded data", err)
+	}
+
+	var buf bytes.Buffer
+	writer, err := DecodeTransform.NewWriter(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writer.Write(expected)
+	if err := writer.Close(); err != nil || !bytes.Equal(buf.Bytes(), input) {
+		t.Error("Unexpected decoded data", err)
+	}
+}
@@ -2,7 +2,11 @@
 package mtf // import "github.com/spaskalev/misc/encoding/mtf"
 
 import (
+	"bytes"
+	"encoding/binary"
+	iou "github.com/spaskalev/misc/ioutil"
 	"io"
+	"math/bits"
 )
 
 // A static table with the initial condition for the mtf algorithm
@@ -29,23 +33,83 @@ type context struct {
 	table [256]byte
 }
 
+// Bytes of ones and of their highest bits, for comparing eight table entries at once
+const (
+	lowOnes  uint64 = 0x0101010101010101
+	highOnes uint64 = 0x8080808080808080
+)
+
+// The number of words of table entries that the encoder shifts a word at a time
+const frontWords = 4
+
+// Returns the highest bit of the first byte of the word that matches
+// the pattern of a repeated value, or zero if there is none
+func match(word uint64, pattern uint64) uint64 {
+	x := word ^ pattern
+	found := (x - lowOnes) &^ x & highOnes
+	return found & -found
+}
+
+// Moves the bytes of the word before the matched one up by one byte,
+// over the matched one, and puts the carry in the first byte
+func shift(word uint64, carry uint64, found uint64) uint64 {
+	mask := found<<1 - 1
+	return word&^mask | (word<<8|carry)&mask
+}
+
 // Encodes data in place
+//
+// The first entries of the table are kept in little endian words, which are
+// searched and shifted a word at a time, as most values of compressible data
+// are found there. The first word is kept apart for the most common case.
+// The rest of the table is searched with bytes.IndexByte and shifted with copy,
+// which is faster than shifting words for deep matches.
 func (c *context) encode(data []byte) {
+	var (
+		words [frontWords]uint64
+		rest  []byte = c.table[frontWords*8:]
+	)
+	for i := range words {
+		words[i] = binary.LittleEndian.Uint64(c.table[i*8:])
+	}
+
+	front := words[0]
+next:
 	for dataIndex, dataValue := range data {
-		// Loop over the MTF table
-		for tableIndex, tableValue := range c.table {
-			if tableValue == dataValue {
-				// Output the value
-				data[dataIndex] = byte(tableIndex)
-
-				// Shift the table
-				copy(c.table[1:], c.table[:tableIndex])
-
-				// Restore the value in front and break
-				c.table[0] = dataValue
-				break
+		var (
+			value   uint64 = uint64(dataValue)
+			pattern uint64 = lowOnes * value
+		)
+
+		if found := match(front, pattern); found != 0 {
+			front = shift(front, value, found)
+			data[dataIndex] = byte(bits.TrailingZeros64(found) / 8)
+			continue
+		}
+
+		// Shift the first word whole and look in the other words
+		carry := front >> 56
+		front = front<<8 | value
+		for index := 1; index < frontWords; index++ {
+			word := words[index]
+			if found := match(word, pattern); found != 0 {
+				words[index] = shift(word, carry, found)
+				data[dataIndex] = byte(index*8 + bits.TrailingZeros64(found)/8)
+				continue next
 			}
+			words[index], carry = word<<8|carry, word>>56
 		}
+
+		// The last entry of the words moves to the front of the rest
+		found := bytes.IndexByte(rest, dataValue)
+		copy(rest[1:found+1], rest[:found])
+		rest[0] = byte(carry)
+		data[dataIndex] = byte(frontWords*8 + found)
+	}
+
+	words[0] = front
+	for i, word := range words {
+		binary.LittleEndian.PutUint64(c.table[i*8:], word)
 	}
 }
 
@@ -104,3 +168,92 @@ func (c *decoder) Read(output []byte) (count int, err error) {
 
 	return count, err
 }
+
+// The size of the scratch buffer of the writers, which is drawn
+// from the shared BufferPool for every write as they have no Close
+const scratchSize = 1024
+
+// Returns an MTF encoder that writes the encoding of the data written to it
+// to the provided io.Writer. The data is encoded in a scratch buffer
+// and is left unmodified.
+//
+// After the underlying writer fails, all writes return its error.
+func NewWriter(writer io.Writer) io.Writer {
+	var enc encodingWriter
+	enc.table = initial
+	enc.target = writer
+	return &enc
+}
+
+// Returns an MTF decoder that writes the decoding of the data written to it
+// to the provided io.Writer. The data is decoded in a scratch buffer
+// and is left unmodified. Errors are handled as in NewWriter.
+func NewDecodingWriter(writer io.Writer) io.Writer {
+	var dec decodingWriter
+	dec.table = initial
+	dec.target = writer
+	return &dec
+}
+
+type scratchWriter struct {
+	context
+	target io.Writer
+	err    error
+}
+
+// Transforms the data in chunks through the scratch buffer and writes it
+func (w *scratchWriter) write(data []byte, transform func(*context, []byte)) (int, error) {
+	scratch := iou.GetBuffer(scratchSize)
+	defer iou.PutBuffer(scratch)
+
+	var total int
+	for w.err == nil && len(data) > 0 {
+		chunk := scratch[:copy(scratch, data)]
+		transform(&w.context, chunk)
+
+		count, err := iou.WriteFull(w.target, chunk)
+		data, total, w.err = data[len(chunk):], total+count, err
+	}
+	return total, w.err
+}
+
+type encodingWriter struct {
+	scratchWriter
+}
+
+// Encode and write the data
+func (w *encodingWriter) Write(data []byte) (int, error) {
+	return w.write(data, (*context).encode)
+}
+
+type decodingWriter struct {
+	scratchWriter
+}
+
+// Decode and write the data
+func (w *decodingWriter) Write(data []byte) (int, error) {
+	return w.write(data, (*context).decode)
+}
+
+// The MTF coders hold no resources and need no flushing
+var nop io.Closer = iou.CloserFunc(func() error { return nil })
+
+// A transform with native reader and writer implementations
+type transform struct {
+	reader func(io.Reader) io.Reader
+	writer func(io.Writer) io.Writer
+}
+
+func (t transform) NewReader(reader io.Reader) (io.ReadCloser, error) {
+	return iou.ReadCloser{Reader: t.reader(reader), Closer: nop}, nil
+}
+
+func (t transform) NewWriter(writer io.Writer) (io.WriteCloser, error) {
+	return iou.WriteCloser{Writer: t.writer(writer), Closer: nop}, nil
+}
+
+// The MTF encoding and decoding as ioutil transforms
+var (
+	EncodeTransform iou.Transform = transform{Encoder, NewWriter}
+	DecodeTransform iou.Transform = transform{Decoder, NewDecodingWriter}
+)
@@ -2,9 +2,15 @@ package mtf // import "github.com/spaskalev/misc/encoding/mtf"
 
 import (
 	"bytes"
+	"errors"
 	diff "github.com/spaskalev/diff"
+	iot "github.com/spaskalev/misc/ioutil/iotest"
 	"io"
+	"io/ioutil"
+	"math/rand"
+	"strings"
 	"testing"
+	"testing/iotest"
 )
 
 func TestMTF(t *testing.T) {
@@ -27,3 +33,214 @@ func TestMTF(t *testing.T) {
 		t.Error("Differences detected ", delta, processed)
 	}
 }
+
+func TestReaderWrappers(t *testing.T) {
+	var data []byte = make([]byte, 4096)
+	for i := range data {
+		data[i] = byte(i * i >> 5)
+	}
+
+	for _, wrapper := range iot.Readers {
+		// Wrap both the encoder's source and the decoder's one, each over a bytes.Reader
+		encoded, eerr := ioutil.ReadAll(Encoder(wrapper.Wrap(bytes.NewReader(data))))
+		result, err := ioutil.ReadAll(Decoder(wrapper.Wrap(bytes.NewReader(encoded))))
+		for _, err := range []error{eerr, err} {
+			if err != nil && err != iotest.ErrTimeout {
+				t.Error(wrapper.Name, "unexpected error", err)
+			}
+		}
+		if !bytes.HasPrefix(data, result) || (eerr == nil && err == nil && len(result) != len(data)) {
+			t.Error(wrapper.Name, "unexpected result of length", len(result))
+		}
+	}
+}
+
+func TestWriters(t *testing.T) {
+	var data []byte = make([]byte, 3*scratchSize+17)
+	for i := range data {
+		data[i] = byte(i * i >> 5)
+	}
+	original := append([]byte(nil), data...)
+	expected, _ := ioutil.ReadAll(Encoder(bytes.NewReader(data)))
+
+	for _, step := range []int{1, 100, scratchSize, len(data)} {
+		var (
+			encoded bytes.Buffer
+			decoded bytes.Buffer
+			enc     io.Writer = NewWriter(&encoded)
+			dec     io.Writer = NewDecodingWriter(&decoded)
+		)
+		for i := 0; i < len(data); i += step {
+			end := i + step
+			if end > len(data) {
+				end = len(data)
+			}
+			if count, err := enc.Write(data[i:end]); count != end-i || err != nil {
+				t.Fatal("Unexpected write", step, count, err)
+			}
+		}
+		if !bytes.Equal(encoded.Bytes(), expected) {
+			t.Error("Unexpected encoded data", step)
+		}
+
+		for i := 0; i < encoded.Len(); i += step {
+			end := i + step
+			if end > encoded.Len() {
+				end = encoded.Len()
+			}
+			dec.Write(encoded.Bytes()[i:end])
+		}
+		if !bytes.Equal(decoded.Bytes(), data) {
+			t.Error("Unexpected decoded data", step)
+		}
+	}
+
+	// The written data is not modified
+	if !bytes.Equal(data, original) {
+		t.Error("The written data is modified")
+	}
+}
+
+func TestWriterErrors(t *testing.T) {
+	var (
+		data []byte = make([]byte, 3*scratchSize)
+		fail error  = errors.New("Invalid write")
+		buf  bytes.Buffer
+		w    io.Writer = NewWriter(iot.ErrorWriter(&buf, scratchSize+10, fail))
+	)
+
+	if count, err := w.Write(data); count != scratchSize+10 || err != fail {
+		t.Error("Unexpected write", count, err)
+	}
+
+	// The error is sticky
+	if count, err := w.Write(data); count != 0 || err != fail {
+		t.Error("Unexpected write after a failure", count, err)
+	}
+
+	// Short writes are reported
+	w = NewDecodingWriter(iot.ShortWriter(&buf, 10))
+	if count, err := w.Write(data); count != 10 || err != io.ErrShortWrite {
+		t.Error("Unexpected short write", count, err)
+	}
+}
+
+func TestTransforms(t *testing.T) {
+	var data []byte = []byte("Lorem ipsum dolor sit amet, consectetur adipiscing elit")
+
+	expected, _ := ioutil.ReadAll(Encoder(bytes.NewReader(data)))
+
+	// Encode on the writing side and decode on the reading side
+	var buf bytes.Buffer
+	writer, err := EncodeTransform.NewWriter(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writer.Write(data)
+	if err := writer.Close(); err != nil || !bytes.Equal(buf.Bytes(), expected) {
+		t.Error("Unexpected encoded data", err)
+	}
+
+	reader, err := DecodeTransform.NewReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result, err := ioutil.ReadAll(reader); err != nil || !bytes.Equal(result, data) {
+		t.Error("Unexpected decoded data", err)
+	}
+}
+
+// The original encoding, which scans and shifts the table a byte at a time
+func referenceEncode(table *[256]byte, data []byte) {
+	for dataIndex, dataValue := range data {
+		for tableIndex, tableValue := range table {
+			if tableValue == dataValue {
+				data[dataIndex] = byte(tableIndex)
+				copy(table[1:], table[:tableIndex])
+				table[0] = dataValue
+				break
+			}
+		}
+	}
+}
+
+func TestEncodeReference(t *testing.T) {
+	for _, text := range []bool{true, false} {
+		var (
+			data     []byte    = benchmarkData(text)[:1<<16]
+			expected []byte    = append([]byte(nil), data...)
+			table    [256]byte = initial
+			ctx      context
+		)
+		referenceEncode(&table, expected)
+
+		// The table carries over across calls
+		ctx.table = initial
+		for i := 0; i < len(data); i += 1000 {
+			end := i + 1000
+			if end > len(data) {
+				end = len(data)
+			}
+			ctx.encode(data[i:end])
+		}
+		if !bytes.Equal(data, expected) || ctx.table != table {
+			t.Error("Unexpected encoding of text", text)
+		}
+	}
+}
+
+// Benchmark input: text-like data with a skewed distribution and random data
+func benchmarkData(text bool) []byte {
+	var (
+		random *rand.Rand = rand.New(rand.NewSource(42))
+		data   []byte     = make([]byte, 1<<20)
+		words  []string   = strings.Fields("the quick brown fox jumps over the lazy dog and then some more words follow in a sentence")
+	)
+	if !text {
+		random.Read(data)
+		return data
+	}
+
+	var buf bytes.Buffer
+	for buf.Len() < len(data) {
+		buf.WriteString(words[random.Intn(len(words))])
+		buf.WriteByte(' ')
+	}
+	return buf.Bytes()[:len(data)]
+}
+
+func benchmarkEncode(b *testing.B, text bool, encode func(*context, []byte)) {
+	var (
+		data []byte = benchmarkData(text)
+		buf  []byte = make([]byte, len(data))
+		ctx  context
+	)
+	b.SetBytes(int64(len(data)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ctx.table = initial
+		copy(buf, data)
+		encode(&ctx, buf)
+	}
+}
+
+// Encodes with the original implementation, as a baseline
+func encodeReference(ctx *context, data []byte) {
+	referenceEncode(&ctx.table, data)
+}
+
+func BenchmarkEncodeText(b *testing.B) {
+	benchmarkEncode(b, true, (*context).encode)
+}
+
+func BenchmarkEncodeRandom(b *testing.B) {
+	benchmarkEncode(b, false, (*context).encode)
+}
+
+func BenchmarkReferenceEncodeText(b *testing.B) {
+	benchmarkEncode(b, true, encodeReference)
+}
+
+func BenchmarkReferenceEncodeRandom(b *testing.B) {
+	benchmarkEncode(b, false, encodeReference)
+}
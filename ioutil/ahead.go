@@ -0,0 +1,264 @@
+package ioutil // import "github.com/spaskalev/misc/ioutil"
+
+import (
+	"io"
+	"sync"
+)
+
+// A block of data read ahead, along with the error of the read
+type block struct {
+	data []byte
+	err  error
+}
+
+// Returns a reader that reads from the provided io.Reader on a separate goroutine,
+// up to the given number of blocks of the given size ahead of the caller.
+// The blocks are drawn from the shared BufferPool.
+//
+// Errors are returned after the data read before them and are not sticky,
+// the reading ends at io.EOF. Close stops the reading and is safe to call
+// concurrently with Read, which then returns ErrClosed. It does not close
+// the underlying reader, and a Read in progress on it completes in the background.
+func ReadAhead(reader io.Reader, blocks int, size int) io.ReadCloser {
+	if blocks < 1 {
+		blocks = 1
+	}
+	if size < 1 {
+		size = 1
+	}
+
+	ra := &readAhead{
+		filled: make(chan block, blocks),
+		free:   make(chan []byte, blocks),
+		done:   make(chan struct{}),
+		size:   size,
+	}
+	for i := 0; i < blocks; i++ {
+		ra.free <- GetBuffer(size)
+	}
+	go ra.run(reader)
+	return ra
+}
+
+type readAhead struct {
+	filled chan block
+	free   chan []byte
+	done   chan struct{}
+	once   sync.Once
+	size   int
+
+	// The block being read by the caller and its unread part
+	buffer  []byte
+	current []byte
+	err     error
+}
+
+// Reads blocks until the end of the stream or until the reader is closed
+func (ra *readAhead) run(reader io.Reader) {
+	defer close(ra.filled)
+	for {
+		var buffer []byte
+		select {
+		case buffer = <-ra.free:
+		case <-ra.done:
+			return
+		}
+
+		var (
+			count int
+			err   error
+		)
+		for i := 0; i < maxEmptyReads && count == 0 && err == nil; i++ {
+			count, err = reader.Read(buffer[:ra.size])
+		}
+		if count == 0 && err == nil {
+			err = io.ErrNoProgress
+		}
+
+		select {
+		case ra.filled <- block{buffer[:count], err}:
+		case <-ra.done:
+			PutBuffer(buffer)
+			return
+		}
+		if err == io.EOF {
+			return
+		}
+	}
+}
+
+func (ra *readAhead) Read(output []byte) (int, error) {
+	select {
+	case <-ra.done:
+		return 0, ErrClosed
+	default:
+	}
+
+	for len(ra.current) == 0 {
+		if ra.buffer != nil {
+			ra.free <- ra.buffer
+			ra.buffer = nil
+		}
+		if err := ra.err; err != nil {
+			if err != io.EOF {
+				ra.err = nil
+			}
+			return 0, err
+		}
+		if len(output) == 0 {
+			return 0, nil
+		}
+
+		select {
+		case next, ok := <-ra.filled:
+			if !ok {
+				return 0, ErrClosed
+			}
+			ra.buffer, ra.current, ra.err = next.data, next.data, next.err
+		case <-ra.done:
+			return 0, ErrClosed
+		}
+	}
+
+	count := copy(output, ra.current)
+	ra.current = ra.current[count:]
+	return count, nil
+}
+
+// Stops the reading and returns the idle blocks to the shared BufferPool
+func (ra *readAhead) Close() error {
+	ra.once.Do(func() {
+		close(ra.done)
+		for {
+			select {
+			case buffer := <-ra.free:
+				PutBuffer(buffer)
+			case next, ok := <-ra.filled:
+				if !ok {
+					return
+				}
+				PutBuffer(next.data)
+			default:
+				return
+			}
+		}
+	})
+	return nil
+}
+
+// Returns a writer that buffers the data written to it into up to the given
+// number of blocks of the given size and writes them to the provided io.Writer
+// on a separate goroutine. The blocks are drawn from the shared BufferPool.
+//
+// As in a BlockWriter the returned counts are the bytes the caller need not retry.
+// An error from the underlying writer is sticky and returned by the calls after it.
+// Close writes out the remaining data and waits for the writing to end.
+//
+// A write in progress on the underlying writer can not be aborted. While it blocks,
+// Write blocks once all blocks are filled and Close blocks until it returns.
+// For cancellation wrap the writer in a ContextWriter, which stops the writing
+// between chunks once its context is done.
+func WriteBehind(writer io.Writer, blocks int, size int) io.WriteCloser {
+	if blocks < 1 {
+		blocks = 1
+	}
+	if size < 1 {
+		size = 1
+	}
+
+	wb := &writeBehind{
+		filled:   make(chan []byte, blocks),
+		free:     make(chan []byte, blocks),
+		finished: make(chan struct{}),
+		size:     size,
+	}
+	for i := 0; i < blocks; i++ {
+		wb.free <- GetBuffer(size)[:0]
+	}
+	go wb.run(writer)
+	return wb
+}
+
+type writeBehind struct {
+	filled   chan []byte
+	free     chan []byte
+	finished chan struct{}
+	size     int
+	closed   bool
+
+	// The block being filled by the caller
+	buffer []byte
+
+	// The error of the underlying writer
+	mutex sync.Mutex
+	err   error
+}
+
+// Writes blocks until the writer is closed, discarding them after an error
+func (wb *writeBehind) run(writer io.Writer) {
+	defer close(wb.finished)
+	for buffer := range wb.filled {
+		if wb.error() == nil {
+			if _, err := WriteFull(writer, buffer); err != nil {
+				wb.mutex.Lock()
+				wb.err = err
+				wb.mutex.Unlock()
+			}
+		}
+		wb.free <- buffer[:0]
+	}
+}
+
+func (wb *writeBehind) error() error {
+	wb.mutex.Lock()
+	defer wb.mutex.Unlock()
+	return wb.err
+}
+
+func (wb *writeBehind) Write(data []byte) (int, error) {
+	if wb.closed {
+		return 0, ErrClosed
+	}
+
+	var total int
+	for len(data) > 0 {
+		if err := wb.error(); err != nil {
+			return total, err
+		}
+		if wb.buffer == nil {
+			wb.buffer = <-wb.free
+		}
+
+		count := copy(wb.buffer[len(wb.buffer):wb.size], data)
+		wb.buffer, data, total = wb.buffer[:len(wb.buffer)+count], data[count:], total+count
+		if len(wb.buffer) == wb.size {
+			wb.filled <- wb.buffer
+			wb.buffer = nil
+		}
+	}
+	return total, nil
+}
+
+// Writes out the remaining data, waits for the writing to end
+// and returns the blocks to the shared BufferPool
+func (wb *writeBehind) Close() error {
+	if wb.closed {
+		return wb.error()
+	}
+	wb.closed = true
+
+	if len(wb.buffer) > 0 {
+		wb.filled <- wb.buffer
+	} else if wb.buffer != nil {
+		PutBuffer(wb.buffer)
+	}
+	wb.buffer = nil
+	close(wb.filled)
+	<-wb.finished
+
+	close(wb.free)
+	for buffer := range wb.free {
+		PutBuffer(buffer)
+	}
+	return wb.error()
+}
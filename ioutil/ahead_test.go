@@ -0,0 +1,199 @@
+package ioutil // import "github.com/spaskalev/misc/ioutil"
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	iot "github.com/spaskalev/misc/ioutil/iotest"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+	"testing/iotest"
+)
+
+// Random test data of the given length
+func randomData(length int) []byte {
+	var (
+		random *rand.Rand = rand.New(rand.NewSource(int64(length)))
+		data   []byte     = make([]byte, length)
+	)
+	random.Read(data)
+	return data
+}
+
+// Reads until the end of the stream, reading on after timeouts
+func readTimeouts(reader io.Reader) ([]byte, error) {
+	var (
+		result []byte
+		buffer []byte = make([]byte, 37)
+	)
+	for {
+		count, err := reader.Read(buffer)
+		result = append(result, buffer[:count]...)
+		if err == io.EOF {
+			return result, nil
+		}
+		if err != nil && err != iotest.ErrTimeout {
+			return result, err
+		}
+	}
+}
+
+func TestReadAhead(t *testing.T) {
+	var data []byte = randomData(10000)
+
+	for _, blocks := range []int{0, 1, 2, 8} {
+		for _, size := range []int{1, 100, 4096} {
+			for _, w := range iot.Readers {
+				r := ReadAhead(w.Wrap(bytes.NewReader(data)), blocks, size)
+				if result, err := readTimeouts(iotest.HalfReader(r)); err != nil || !bytes.Equal(result, data) {
+					t.Error("Unexpected result", blocks, size, w.Name, len(result), err)
+				}
+				if err := r.Close(); err != nil {
+					t.Error("Unexpected error from Close", err)
+				}
+			}
+		}
+	}
+}
+
+func TestReadAheadErrors(t *testing.T) {
+	var (
+		fail error     = errors.New("Invalid read")
+		r    io.Reader = io.MultiReader(bytes.NewReader([]byte("0123")),
+			ReaderFunc(func([]byte) (int, error) { return 0, fail }))
+	)
+
+	// The data comes before the error
+	ra := ReadAhead(r, 2, 2)
+	if result, err := ioutil.ReadAll(ra); err != fail || string(result) != "0123" {
+		t.Error("Unexpected result", result, err)
+	}
+	ra.Close()
+
+	// The end of the stream is sticky
+	ra = ReadAhead(bytes.NewReader(nil), 2, 2)
+	for i := 0; i < 3; i++ {
+		if count, err := ra.Read(make([]byte, 1)); count != 0 || err != io.EOF {
+			t.Error("Unexpected read at the end", count, err)
+		}
+	}
+	ra.Close()
+
+	// Empty reads are retried up to a limit
+	ra = ReadAhead(ReaderFunc(func([]byte) (int, error) { return 0, nil }), 1, 1)
+	if _, err := ra.Read(make([]byte, 1)); err != io.ErrNoProgress {
+		t.Error("Unexpected error for empty reads", err)
+	}
+	ra.Close()
+}
+
+func TestReadAheadClose(t *testing.T) {
+	var (
+		release chan struct{} = make(chan struct{})
+		blocked io.Reader     = ReaderFunc(func([]byte) (int, error) {
+			<-release
+			return 0, io.EOF
+		})
+		ra     io.ReadCloser = ReadAhead(blocked, 2, 16)
+		result chan error    = make(chan error)
+	)
+	defer close(release)
+
+	// A blocked Read is interrupted by Close
+	go func() {
+		_, err := ra.Read(make([]byte, 1))
+		result <- err
+	}()
+	if err := ra.Close(); err != nil {
+		t.Error("Unexpected error from Close", err)
+	}
+	if err := <-result; err != ErrClosed {
+		t.Error("Unexpected error from an interrupted Read", err)
+	}
+
+	if count, err := ra.Read(make([]byte, 1)); count != 0 || err != ErrClosed {
+		t.Error("Unexpected read after Close", count, err)
+	}
+	if err := ra.Close(); err != nil {
+		t.Error("Unexpected error from a second Close", err)
+	}
+}
+
+func TestWriteBehind(t *testing.T) {
+	var data []byte = randomData(10000)
+
+	for _, blocks := range []int{0, 1, 2, 8} {
+		for _, size := range []int{1, 100, 4096} {
+			for _, step := range []int{1, 7, 1000, len(data)} {
+				var (
+					buf bytes.Buffer
+					w   io.WriteCloser = WriteBehind(&buf, blocks, size)
+				)
+				for i := 0; i < len(data); i += step {
+					end := i + step
+					if end > len(data) {
+						end = len(data)
+					}
+					if count, err := w.Write(data[i:end]); count != end-i || err != nil {
+						t.Fatal("Unexpected write", blocks, size, step, count, err)
+					}
+				}
+				if err := w.Close(); err != nil || !bytes.Equal(buf.Bytes(), data) {
+					t.Error("Unexpected result", blocks, size, step, buf.Len(), err)
+				}
+			}
+		}
+	}
+}
+
+func TestWriteBehindErrors(t *testing.T) {
+	var (
+		data []byte = randomData(10000)
+		fail error  = errors.New("Invalid write")
+		buf  bytes.Buffer
+		w    io.WriteCloser = WriteBehind(iot.ErrorWriter(&buf, 1000, fail), 2, 100)
+	)
+
+	// The error is returned by a later Write
+	var err error
+	for i := 0; i < len(data) && err == nil; i += 10 {
+		_, err = w.Write(data[i : i+10])
+	}
+	if err != fail || !bytes.Equal(buf.Bytes(), data[:1000]) {
+		t.Error("Unexpected error", err, buf.Len())
+	}
+
+	// The error is sticky
+	if count, err := w.Write(data); count != 0 || err != fail {
+		t.Error("Unexpected write after a failure", count, err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := w.Close(); err != fail {
+			t.Error("Unexpected error from Close after a failure", err)
+		}
+	}
+
+	// Short writes are reported
+	buf.Reset()
+	w = WriteBehind(iot.ShortWriter(&buf, 10), 2, 100)
+	w.Write(data[:100])
+	if err := w.Close(); err != io.ErrShortWrite {
+		t.Error("Unexpected error for a short write", err)
+	}
+
+	// Writing to a closed writer fails
+	if count, err := w.Write(data); count != 0 || err != ErrClosed {
+		t.Error("Unexpected write after Close", count, err)
+	}
+
+	// Cancellation reaches the writing through a ContextWriter
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	w = WriteBehind(ContextWriter(ctx, &buf), 2, 100)
+	w.Write(data)
+	if err := w.Close(); err != context.Canceled {
+		t.Error("Unexpected error after cancellation", err)
+	}
+}
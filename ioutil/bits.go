@@ -0,0 +1,322 @@
+package ioutil // import "github.com/spaskalev/misc/ioutil"
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// The order in which the bits of a bit stream fill its bytes
+type BitOrder int
+
+const (
+	// The first bit of the stream is the least significant bit of its first byte.
+	// The bits of a value are written starting from its least significant one.
+	LSB BitOrder = iota
+
+	// The first bit of the stream is the most significant bit of its first byte.
+	// The bits of a value are written starting from its most significant one.
+	MSB
+)
+
+// The size of the byte buffers of the bit readers and writers,
+// which are drawn from the shared BufferPool
+const bitBufferSize = 512
+
+// The number of consecutive empty reads after which reading gives up with io.ErrNoProgress
+const maxEmptyReads = 100
+
+// ErrBitCount is returned for reads and writes of more than 64 bits,
+// or for peeks of more than 56 bits
+var ErrBitCount = errors.New("ioutil: invalid bit count")
+
+// Returns a mask of the lowest count bits
+func lowBits(count uint) uint64 {
+	return uint64(1)<<count - 1
+}
+
+// A BitWriter writes values of up to 64 bits to an underlying io.Writer.
+//
+// Complete bytes are buffered until the buffer fills or Flush is called,
+// which returns the buffer to the shared BufferPool. After a failed write
+// to the underlying writer all calls return its error.
+type BitWriter struct {
+	writer io.Writer
+	order  BitOrder
+	err    error
+
+	// The bits that do not yet form a complete byte, in the low bits
+	// for LSB order and in the high bits for MSB order
+	bits  uint64
+	count uint
+
+	buffer []byte
+	length int
+}
+
+// Returns a new BitWriter that writes bits in the given order to the provided io.Writer
+func NewBitWriter(writer io.Writer, order BitOrder) *BitWriter {
+	var bw BitWriter
+	bw.writer, bw.order = writer, order
+	return &bw
+}
+
+// Writes the lowest count bits of the value, where count is between 0 and 64
+func (bw *BitWriter) WriteBits(value uint64, count uint) error {
+	if count > 64 {
+		return ErrBitCount
+	}
+	if bw.err != nil {
+		return bw.err
+	}
+
+	// The pending bits and the written ones must fit in 64 bits
+	if count > 56 {
+		value &= lowBits(count)
+		if bw.order == LSB {
+			bw.write(value&lowBits(32), 32)
+			bw.write(value>>32, count-32)
+		} else {
+			bw.write(value>>32, count-32)
+			bw.write(value&lowBits(32), 32)
+		}
+	} else {
+		bw.write(value&lowBits(count), count)
+	}
+	return bw.err
+}
+
+// Adds up to 56 bits to the pending ones and stages the complete bytes
+func (bw *BitWriter) write(value uint64, count uint) {
+	if bw.order == LSB {
+		bw.bits |= value << bw.count
+	} else {
+		bw.bits |= value << (64 - bw.count - count)
+	}
+
+	for bw.count += count; bw.count >= 8; bw.count -= 8 {
+		if bw.order == LSB {
+			bw.stage(byte(bw.bits))
+			bw.bits >>= 8
+		} else {
+			bw.stage(byte(bw.bits >> 56))
+			bw.bits <<= 8
+		}
+	}
+}
+
+// Adds a byte to the buffer, writing the buffer out when it is full
+func (bw *BitWriter) stage(value byte) {
+	if bw.buffer == nil {
+		bw.buffer = GetBuffer(bitBufferSize)
+	}
+
+	bw.buffer[bw.length] = value
+	if bw.length++; bw.length == len(bw.buffer) && bw.err == nil {
+		bw.err = bw.flush()
+	}
+}
+
+// Writes the buffered bytes to the underlying writer
+func (bw *BitWriter) flush() error {
+	_, err := WriteFull(bw.writer, bw.buffer[:bw.length])
+	bw.length = 0
+	return err
+}
+
+// Pads the pending bits with zeros up to a byte boundary
+func (bw *BitWriter) Align() error {
+	if bw.count > 0 {
+		return bw.WriteBits(0, 8-bw.count)
+	}
+	return bw.err
+}
+
+// Pads the pending bits with zeros up to a byte boundary
+// and writes the buffered bytes to the underlying writer
+func (bw *BitWriter) Flush() error {
+	if err := bw.Align(); err != nil || bw.buffer == nil {
+		return err
+	}
+
+	if bw.length > 0 {
+		bw.err = bw.flush()
+	}
+	PutBuffer(bw.buffer)
+	bw.buffer = nil
+	return bw.err
+}
+
+// Discards any pending bits, buffered bytes and errors
+// and makes the BitWriter write to the provided io.Writer
+func (bw *BitWriter) Reset(writer io.Writer) {
+	bw.writer, bw.err = writer, nil
+	bw.bits, bw.count, bw.length = 0, 0, 0
+}
+
+// A BitReader reads values of up to 64 bits from an underlying io.Reader.
+//
+// It reads ahead from the underlying reader into a buffer. Errors from it are
+// returned once the buffered bits are exhausted and are not sticky.
+// The buffer is returned to the shared BufferPool on errors.
+type BitReader struct {
+	reader io.Reader
+	order  BitOrder
+	err    error
+
+	// The bits that are taken from the buffer but not yet read, in the
+	// low bits for LSB order and in the high bits for MSB order.
+	// The rest of the bits are always zero.
+	bits  uint64
+	count uint
+
+	buffer   []byte
+	from, to int
+}
+
+// Returns a new BitReader that reads bits in the given order from the provided io.Reader
+func NewBitReader(reader io.Reader, order BitOrder) *BitReader {
+	var br BitReader
+	br.reader, br.order = reader, order
+	return &br
+}
+
+// Takes bytes from the buffer, reading into it as needed,
+// until there are at least count bits or an error occurs
+func (br *BitReader) fill(count uint) {
+	for br.count < count {
+		// Take as many whole bytes as possible at once
+		if br.to-br.from >= 8 {
+			var (
+				taken uint   = (64 - br.count) / 8 * 8
+				word  []byte = br.buffer[br.from : br.from+8]
+			)
+			if br.order == LSB {
+				br.bits |= (binary.LittleEndian.Uint64(word) & lowBits(taken)) << br.count
+			} else {
+				br.bits |= (binary.BigEndian.Uint64(word) >> (64 - taken) << (64 - taken)) >> br.count
+			}
+			br.from, br.count = br.from+int(taken/8), br.count+taken
+			continue
+		}
+
+		if br.from < br.to {
+			if br.order == LSB {
+				br.bits |= uint64(br.buffer[br.from]) << br.count
+			} else {
+				br.bits |= uint64(br.buffer[br.from]) << (56 - br.count)
+			}
+			br.from, br.count = br.from+1, br.count+8
+			continue
+		}
+
+		if br.err != nil {
+			if br.buffer != nil {
+				PutBuffer(br.buffer)
+				br.buffer = nil
+			}
+			return
+		}
+		br.read()
+	}
+}
+
+// Reads from the underlying reader into the empty buffer
+func (br *BitReader) read() {
+	if br.buffer == nil {
+		br.buffer = GetBuffer(bitBufferSize)
+	}
+
+	for i := 0; i < maxEmptyReads; i++ {
+		br.from = 0
+		br.to, br.err = br.reader.Read(br.buffer)
+		if br.to > 0 || br.err != nil {
+			return
+		}
+	}
+	br.err = io.ErrNoProgress
+}
+
+// Returns the next count bits, where count is between 0 and 56, without consuming them.
+//
+// If the stream ends before them the available bits are returned, padded with zeros,
+// along with io.ErrUnexpectedEOF, or io.EOF if there are no bits left.
+func (br *BitReader) PeekBits(count uint) (uint64, error) {
+	if count > 56 {
+		return 0, ErrBitCount
+	}
+
+	br.fill(count)
+
+	var value uint64
+	if count > 0 {
+		if br.order == LSB {
+			value = br.bits & lowBits(count)
+		} else {
+			value = br.bits >> (64 - count)
+		}
+	}
+
+	if br.count >= count {
+		return value, nil
+	}
+
+	// Report the error once
+	err := br.err
+	br.err = nil
+	if err == io.EOF && br.count > 0 {
+		err = io.ErrUnexpectedEOF
+	}
+	return value, err
+}
+
+// Reads the next count bits, where count is between 0 and 64.
+//
+// If the stream ends before them it returns the same errors as PeekBits
+// and no bits are consumed, except for reads of more than 56 bits
+// for which the first part of them can be consumed.
+func (br *BitReader) ReadBits(count uint) (uint64, error) {
+	if count > 64 {
+		return 0, ErrBitCount
+	}
+
+	if count > 56 {
+		first, err := br.ReadBits(32)
+		if err != nil {
+			return first, err
+		}
+		second, err := br.ReadBits(count - 32)
+		if br.order == LSB {
+			return first | second<<32, err
+		}
+		return first<<(count-32) | second, err
+	}
+
+	value, err := br.PeekBits(count)
+	if err == nil {
+		br.skip(count)
+	}
+	return value, err
+}
+
+// Consumes count of the available bits
+func (br *BitReader) skip(count uint) {
+	if br.order == LSB {
+		br.bits >>= count
+	} else {
+		br.bits <<= count
+	}
+	br.count -= count
+}
+
+// Discards the bits up to the next byte boundary
+func (br *BitReader) Align() {
+	br.skip(br.count % 8)
+}
+
+// Discards any buffered bits and errors
+// and makes the BitReader read from the provided io.Reader
+func (br *BitReader) Reset(reader io.Reader) {
+	br.reader, br.err = reader, nil
+	br.bits, br.count, br.from, br.to = 0, 0, 0, 0
+}
@@ -0,0 +1,228 @@
+package ioutil // import "github.com/spaskalev/misc/ioutil"
+
+import (
+	"bytes"
+	"errors"
+	iot "github.com/spaskalev/misc/ioutil/iotest"
+	"io"
+	"math/rand"
+	"testing"
+	"testing/iotest"
+)
+
+func TestBitWriterOrder(t *testing.T) {
+	var cases = []struct {
+		order    BitOrder
+		expected []byte
+	}{
+		{LSB, []byte{0xdb, 0xbc, 0x0a}},
+		{MSB, []byte{0xda, 0xbc, 0xd0}},
+	}
+
+	for _, c := range cases {
+		var (
+			buf bytes.Buffer
+			bw  *BitWriter = NewBitWriter(&buf, c.order)
+		)
+
+		bw.WriteBits(1, 1)
+		bw.WriteBits(5, 3)
+		bw.WriteBits(0xabcd, 16)
+		if err := bw.Flush(); err != nil {
+			t.Error("Unexpected error from Flush", err)
+		}
+
+		// The last byte is padded with zeros
+		if !bytes.Equal(buf.Bytes(), c.expected) {
+			t.Errorf("Unexpected output for order %d: %#x", c.order, buf.Bytes())
+		}
+	}
+}
+
+// A sequence of values and their bit counts
+func bitValues(count int) (values []uint64, counts []uint) {
+	var random *rand.Rand = rand.New(rand.NewSource(int64(count)))
+	for i := 0; i < count; i++ {
+		bits := uint(random.Intn(65))
+		values = append(values, random.Uint64()&lowBits(bits))
+		counts = append(counts, bits)
+	}
+	return
+}
+
+func TestBitCycle(t *testing.T) {
+	values, counts := bitValues(10000)
+
+	for _, order := range []BitOrder{LSB, MSB} {
+		var (
+			buf bytes.Buffer
+			bw  *BitWriter = NewBitWriter(&buf, order)
+		)
+
+		for i := range values {
+			if err := bw.WriteBits(values[i]|^lowBits(counts[i]), counts[i]); err != nil {
+				t.Fatal(err)
+			}
+		}
+		bw.Flush()
+
+		var readers = []struct {
+			name   string
+			reader io.Reader
+		}{
+			{"bytes", bytes.NewReader(buf.Bytes())},
+			{"one byte", iotest.OneByteReader(bytes.NewReader(buf.Bytes()))},
+			{"half", iotest.HalfReader(bytes.NewReader(buf.Bytes()))},
+		}
+
+		for _, r := range readers {
+			br := NewBitReader(r.reader, order)
+			for i := range values {
+				if counts[i] <= 56 {
+					if value, err := br.PeekBits(counts[i]); err != nil || value != values[i] {
+						t.Fatal("Unexpected peek", order, r.name, i, value, values[i], err)
+					}
+				}
+				if value, err := br.ReadBits(counts[i]); err != nil || value != values[i] {
+					t.Fatal("Unexpected value", order, r.name, i, value, values[i], err)
+				}
+			}
+
+			// Only the padding remains
+			br.Align()
+			if _, err := br.ReadBits(1); err != io.EOF {
+				t.Error("Unexpected end of stream", order, r.name, err)
+			}
+		}
+	}
+}
+
+func TestBitAlign(t *testing.T) {
+	for _, order := range []BitOrder{LSB, MSB} {
+		var (
+			buf bytes.Buffer
+			bw  *BitWriter = NewBitWriter(&buf, order)
+		)
+
+		bw.WriteBits(1, 3)
+		bw.Align()
+		bw.WriteBits(0xff, 8)
+		bw.Align()
+		bw.Flush()
+		if buf.Len() != 2 {
+			t.Error("Unexpected length of aligned output", buf.Len())
+		}
+
+		br := NewBitReader(&buf, order)
+		if value, _ := br.ReadBits(3); value != 1 {
+			t.Error("Unexpected value", value)
+		}
+		br.Align()
+		if value, err := br.ReadBits(8); value != 0xff || err != nil {
+			t.Error("Unexpected aligned value", value, err)
+		}
+	}
+}
+
+func TestBitReaderEnd(t *testing.T) {
+	br := NewBitReader(bytes.NewReader([]byte{0xff}), LSB)
+
+	if _, err := br.ReadBits(4); err != nil {
+		t.Error("Unexpected error", err)
+	}
+
+	// A partial value is not consumed
+	if value, err := br.ReadBits(5); value != 0xf || err != io.ErrUnexpectedEOF {
+		t.Error("Unexpected partial value", value, err)
+	}
+	if value, err := br.ReadBits(4); value != 0xf || err != nil {
+		t.Error("Unexpected value", value, err)
+	}
+	if _, err := br.PeekBits(1); err != io.EOF {
+		t.Error("Unexpected error at the end", err)
+	}
+
+	if _, err := br.ReadBits(65); err != ErrBitCount {
+		t.Error("Unexpected error for an invalid read", err)
+	}
+	if _, err := br.PeekBits(57); err != ErrBitCount {
+		t.Error("Unexpected error for an invalid peek", err)
+	}
+}
+
+func TestBitReaderErrors(t *testing.T) {
+	// Errors are not sticky
+	br := NewBitReader(iotest.TimeoutReader(iotest.OneByteReader(bytes.NewReader([]byte{1, 2}))), MSB)
+	if value, err := br.ReadBits(16); value != 0x0100 || err != iotest.ErrTimeout {
+		t.Error("Unexpected value", value, err)
+	}
+	if value, err := br.ReadBits(16); value != 0x0102 || err != nil {
+		t.Error("Unexpected value after an error", value, err)
+	}
+
+	// Empty reads are retried up to a limit
+	br = NewBitReader(ReaderFunc(func([]byte) (int, error) { return 0, nil }), MSB)
+	if _, err := br.ReadBits(1); err != io.ErrNoProgress {
+		t.Error("Unexpected error for empty reads", err)
+	}
+}
+
+func TestBitWriterErrors(t *testing.T) {
+	var (
+		fail error = errors.New("Invalid write")
+		buf  bytes.Buffer
+		bw   *BitWriter = NewBitWriter(iot.ErrorWriter(&buf, 10, fail), LSB)
+	)
+
+	var err error
+	for i := 0; i < bitBufferSize && err == nil; i++ {
+		err = bw.WriteBits(uint64(i), 8)
+	}
+	if err != fail || buf.Len() != 10 {
+		t.Error("Unexpected error", err, buf.Len())
+	}
+
+	// The error is sticky
+	if err := bw.WriteBits(1, 1); err != fail {
+		t.Error("Unexpected error after a failure", err)
+	}
+	if err := bw.Flush(); err != fail {
+		t.Error("Unexpected error from Flush after a failure", err)
+	}
+	if err := bw.WriteBits(1, 65); err != ErrBitCount {
+		t.Error("Unexpected error for an invalid write", err)
+	}
+
+	// The writer is usable after a reset
+	buf.Reset()
+	bw.Reset(&buf)
+	bw.WriteBits(0xab, 8)
+	if err := bw.Flush(); err != nil || !bytes.Equal(buf.Bytes(), []byte{0xab}) {
+		t.Error("Unexpected output after a reset", buf.Bytes(), err)
+	}
+}
+
+func BenchmarkBitReader(b *testing.B) {
+	values, counts := bitValues(1 << 16)
+
+	var buf bytes.Buffer
+	bw := NewBitWriter(&buf, LSB)
+	for i := range values {
+		bw.WriteBits(values[i], counts[i])
+	}
+	bw.Flush()
+
+	var (
+		data   []byte = buf.Bytes()
+		reader *bytes.Reader
+		br     *BitReader = NewBitReader(nil, LSB)
+	)
+	b.SetBytes(int64(len(data)))
+	for i := 0; i < b.N; i++ {
+		reader = bytes.NewReader(data)
+		br.Reset(reader)
+		for _, count := range counts {
+			br.ReadBits(count)
+		}
+	}
+}
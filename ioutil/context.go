@@ -0,0 +1,73 @@
+package ioutil // import "github.com/spaskalev/misc/ioutil"
+
+import (
+	"context"
+	"io"
+)
+
+// The size of the chunks in which context-aware writers and copies
+// check their context
+const contextChunkSize = 32 << 10
+
+// Returns a reader that reads from the provided io.Reader until the context
+// is done, after which reads fail with the context's error.
+// A read that is in progress on the underlying reader is not interrupted.
+func ContextReader(ctx context.Context, reader io.Reader) io.Reader {
+	return &contextReader{ctx, reader}
+}
+
+type contextReader struct {
+	ctx    context.Context
+	reader io.Reader
+}
+
+func (cr *contextReader) Read(output []byte) (int, error) {
+	if err := cr.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return cr.reader.Read(output)
+}
+
+// Returns a writer that writes to the provided io.Writer in chunks until
+// the context is done, after which writes fail with the context's error.
+// A write that is in progress on the underlying writer is not interrupted.
+func ContextWriter(ctx context.Context, writer io.Writer) io.Writer {
+	return &contextWriter{ctx, writer}
+}
+
+type contextWriter struct {
+	ctx    context.Context
+	writer io.Writer
+}
+
+func (cw *contextWriter) Write(data []byte) (int, error) {
+	var total int
+	for {
+		if err := cw.ctx.Err(); err != nil {
+			return total, err
+		}
+
+		chunk := data
+		if len(chunk) > contextChunkSize {
+			chunk = chunk[:contextChunkSize]
+		}
+		count, err := WriteFull(cw.writer, chunk)
+		if total += count; err != nil {
+			return total, err
+		}
+		if data = data[count:]; len(data) == 0 {
+			return total, nil
+		}
+	}
+}
+
+// Copies from the provided io.Reader to the provided io.Writer until the end
+// of the stream, an error or until the context is done, in which case
+// the context's error is returned. The copy buffer is drawn from the shared BufferPool.
+func CopyContext(ctx context.Context, writer io.Writer, reader io.Reader) (int64, error) {
+	buffer := GetBuffer(contextChunkSize)
+	defer PutBuffer(buffer)
+
+	// The wrappers hide any io.WriterTo or io.ReaderFrom, which would copy in one go
+	return io.CopyBuffer(ContextWriter(ctx, writer), ContextReader(ctx, reader), buffer)
+}
@@ -0,0 +1,93 @@
+package ioutil // import "github.com/spaskalev/misc/ioutil"
+
+import (
+	"bytes"
+	"context"
+	iot "github.com/spaskalev/misc/ioutil/iotest"
+	"io"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+func TestContextReader(t *testing.T) {
+	var data []byte = randomData(1000)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	r := ContextReader(ctx, bytes.NewReader(data))
+	if count, err := r.Read(make([]byte, 10)); count != 10 || err != nil {
+		t.Error("Unexpected read", count, err)
+	}
+
+	cancel()
+	if count, err := r.Read(make([]byte, 10)); count != 0 || err != context.Canceled {
+		t.Error("Unexpected read after cancel", count, err)
+	}
+
+	// Deadlines are reported as well
+	ctx, cancel = context.WithTimeout(context.Background(), time.Nanosecond)
+	defer cancel()
+	<-ctx.Done()
+	if result, err := ioutil.ReadAll(ContextReader(ctx, bytes.NewReader(data))); len(result) != 0 || err != context.DeadlineExceeded {
+		t.Error("Unexpected read after the deadline", len(result), err)
+	}
+}
+
+func TestContextWriter(t *testing.T) {
+	var (
+		data []byte = randomData(3*contextChunkSize + 1)
+		buf  bytes.Buffer
+	)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	if count, err := ContextWriter(ctx, &buf).Write(data); count != len(data) || err != nil || !bytes.Equal(buf.Bytes(), data) {
+		t.Error("Unexpected write", count, err)
+	}
+
+	// The context is checked between chunks
+	buf.Reset()
+	w := ContextWriter(ctx, WriterFunc(func(chunk []byte) (int, error) {
+		cancel()
+		return buf.Write(chunk)
+	}))
+	if count, err := w.Write(data); count != contextChunkSize || err != context.Canceled {
+		t.Error("Unexpected write after cancel", count, err)
+	}
+
+	// Short writes are reported
+	buf.Reset()
+	w = ContextWriter(context.Background(), iot.ShortWriter(&buf, 10))
+	if count, err := w.Write(data); count != 10 || err != io.ErrShortWrite {
+		t.Error("Unexpected short write", count, err)
+	}
+}
+
+func TestCopyContext(t *testing.T) {
+	var (
+		data []byte = randomData(10 * contextChunkSize)
+		buf  bytes.Buffer
+	)
+
+	if count, err := CopyContext(context.Background(), &buf, bytes.NewReader(data)); count != int64(len(data)) || err != nil {
+		t.Error("Unexpected copy", count, err)
+	}
+	if !bytes.Equal(buf.Bytes(), data) {
+		t.Error("Unexpected copied data")
+	}
+
+	// A copy is aborted between chunks
+	buf.Reset()
+	ctx, cancel := context.WithCancel(context.Background())
+	var reads int
+	r := ReaderFunc(func(output []byte) (int, error) {
+		if reads++; reads == 3 {
+			cancel()
+		}
+		return copy(output, data[:100]), nil
+	})
+
+	// The data of the read that cancels is not written
+	if count, err := CopyContext(ctx, &buf, r); count != 200 || err != context.Canceled {
+		t.Error("Unexpected cancelled copy", count, err)
+	}
+}
@@ -0,0 +1,117 @@
+package ioutil // import "github.com/spaskalev/misc/ioutil"
+
+import (
+	"hash"
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+// A CountingReader counts the bytes read from an underlying io.Reader.
+// Count is safe to call concurrently with Read.
+type CountingReader struct {
+	// First for the alignment of the atomic operations
+	count  int64
+	reader io.Reader
+}
+
+// Returns a new CountingReader over the provided io.Reader
+func NewCountingReader(reader io.Reader) *CountingReader {
+	return &CountingReader{reader: reader}
+}
+
+// Implements io.Reader
+func (cr *CountingReader) Read(output []byte) (int, error) {
+	count, err := cr.reader.Read(output)
+	atomic.AddInt64(&cr.count, int64(count))
+	return count, err
+}
+
+// Returns the number of bytes read so far
+func (cr *CountingReader) Count() int64 {
+	return atomic.LoadInt64(&cr.count)
+}
+
+// A CountingWriter counts the bytes written to an underlying io.Writer.
+// Count is safe to call concurrently with Write.
+type CountingWriter struct {
+	// First for the alignment of the atomic operations
+	count  int64
+	writer io.Writer
+}
+
+// Returns a new CountingWriter over the provided io.Writer
+func NewCountingWriter(writer io.Writer) *CountingWriter {
+	return &CountingWriter{writer: writer}
+}
+
+// Implements io.Writer
+func (cw *CountingWriter) Write(data []byte) (int, error) {
+	count, err := cw.writer.Write(data)
+	atomic.AddInt64(&cw.count, int64(count))
+	return count, err
+}
+
+// Returns the number of bytes written so far
+func (cw *CountingWriter) Count() int64 {
+	return atomic.LoadInt64(&cw.count)
+}
+
+// Returns a reader that feeds the data read from the provided io.Reader to the hash.
+// Use io.MultiWriter for hashing written data.
+func TeeHash(reader io.Reader, hash hash.Hash) io.Reader {
+	return io.TeeReader(reader, hash)
+}
+
+// Reports a running total to a callback at most once per interval
+type progress struct {
+	interval time.Duration
+	report   func(int64)
+	total    int64
+	last     time.Time
+}
+
+// Accounts for the transferred bytes and reports the total
+// if the interval has passed or if there is an error
+func (p *progress) update(count int, err error) {
+	p.total += int64(count)
+	if now := time.Now(); err != nil || now.Sub(p.last) >= p.interval {
+		p.last = now
+		p.report(p.total)
+	}
+}
+
+// Returns a reader that reports the total number of bytes read from the provided
+// io.Reader to the callback at most once per interval, and on every error,
+// including the end of the stream.
+func ProgressReader(reader io.Reader, interval time.Duration, report func(total int64)) io.Reader {
+	return &progressReader{reader, progress{interval, report, 0, time.Now()}}
+}
+
+type progressReader struct {
+	reader io.Reader
+	progress
+}
+
+func (pr *progressReader) Read(output []byte) (int, error) {
+	count, err := pr.reader.Read(output)
+	pr.update(count, err)
+	return count, err
+}
+
+// Returns a writer that reports the total number of bytes written to the provided
+// io.Writer to the callback at most once per interval, and on every error.
+func ProgressWriter(writer io.Writer, interval time.Duration, report func(total int64)) io.Writer {
+	return &progressWriter{writer, progress{interval, report, 0, time.Now()}}
+}
+
+type progressWriter struct {
+	writer io.Writer
+	progress
+}
+
+func (pw *progressWriter) Write(data []byte) (int, error) {
+	count, err := pw.writer.Write(data)
+	pw.update(count, err)
+	return count, err
+}
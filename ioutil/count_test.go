@@ -0,0 +1,103 @@
+package ioutil // import "github.com/spaskalev/misc/ioutil"
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io"
+	"io/ioutil"
+	"sync"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+func TestCounting(t *testing.T) {
+	var (
+		input  []byte          = make([]byte, 1000)
+		reader *CountingReader = NewCountingReader(iotest.HalfReader(bytes.NewReader(input)))
+		writer *CountingWriter = NewCountingWriter(ioutil.Discard)
+		done   chan struct{}   = make(chan struct{})
+		wg     sync.WaitGroup
+	)
+
+	// Counts are safe to read during the copy
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+				if reader.Count() < writer.Count() {
+					t.Error("Written more than read")
+				}
+			}
+		}
+	}()
+
+	count, err := io.Copy(writer, reader)
+	close(done)
+	wg.Wait()
+
+	if count != 1000 || err != nil || reader.Count() != 1000 || writer.Count() != 1000 {
+		t.Error("Unexpected counts", count, err, reader.Count(), writer.Count())
+	}
+}
+
+func TestTeeHash(t *testing.T) {
+	var (
+		input []byte = []byte("0123456789")
+		hash         = sha256.New()
+	)
+
+	result, err := ioutil.ReadAll(TeeHash(iotest.OneByteReader(bytes.NewReader(input)), hash))
+	if err != nil || !bytes.Equal(result, input) {
+		t.Error("Unexpected result", result, err)
+	}
+	if expected := sha256.Sum256(input); !bytes.Equal(hash.Sum(nil), expected[:]) {
+		t.Error("Unexpected hash")
+	}
+}
+
+func TestProgressReader(t *testing.T) {
+	var (
+		input   []byte = make([]byte, 100)
+		reports []int64
+	)
+
+	// Only the end of the stream is reported within a long interval
+	reader := ProgressReader(iotest.OneByteReader(bytes.NewReader(input)), time.Hour, func(total int64) {
+		reports = append(reports, total)
+	})
+	ioutil.ReadAll(reader)
+	if len(reports) != 1 || reports[0] != 100 {
+		t.Error("Unexpected reports", reports)
+	}
+
+	// Every read is reported without an interval
+	reports = nil
+	reader = ProgressReader(iotest.OneByteReader(bytes.NewReader(input)), 0, func(total int64) {
+		reports = append(reports, total)
+	})
+	ioutil.ReadAll(reader)
+	if len(reports) != 101 || reports[50] != 51 || reports[100] != 100 {
+		t.Error("Unexpected reports", reports)
+	}
+}
+
+func TestProgressWriter(t *testing.T) {
+	var (
+		buffer  bytes.Buffer
+		reports []int64
+		writer  io.Writer = ProgressWriter(&buffer, 0, func(total int64) {
+			reports = append(reports, total)
+		})
+	)
+
+	writer.Write([]byte("12"))
+	writer.Write([]byte("345"))
+	if len(reports) != 2 || reports[1] != 5 || buffer.String() != "12345" {
+		t.Error("Unexpected reports", reports, buffer.String())
+	}
+}
@@ -0,0 +1,66 @@
+// Package iotest contains writers that misbehave in the ways permitted
+// by the io contracts, for testing stream codecs. They complement
+// the readers of the standard testing/iotest package.
+package iotest // import "github.com/spaskalev/misc/ioutil/iotest"
+
+import (
+	"io"
+	"testing/iotest"
+)
+
+// The readers of testing/iotest by name, for running a test against each of them.
+// DataErrReader may lose data that its reader returns together with an error,
+// so the readers are best applied to ones that return errors in a separate call.
+var Readers = []struct {
+	Name string
+	Wrap func(io.Reader) io.Reader
+}{
+	{"OneByteReader", iotest.OneByteReader},
+	{"HalfReader", iotest.HalfReader},
+	{"DataErrReader", iotest.DataErrReader},
+	{"TimeoutReader", iotest.TimeoutReader},
+}
+
+// Returns a writer that writes up to a limit of bytes in total to the provided io.Writer.
+// A write beyond the limit writes the bytes up to it and returns their count with
+// the given error, or with no error at all if it is nil.
+func ErrorWriter(writer io.Writer, limit int, err error) io.Writer {
+	return &errorWriter{writer, limit, err}
+}
+
+type errorWriter struct {
+	writer io.Writer
+	limit  int
+	err    error
+}
+
+func (w *errorWriter) Write(data []byte) (int, error) {
+	var failed bool
+	if len(data) > w.limit {
+		data, failed = data[:w.limit], true
+	}
+
+	count, err := w.writer.Write(data)
+	if w.limit -= count; err == nil && failed {
+		err = w.err
+	}
+	return count, err
+}
+
+// Returns a writer that writes at most the given number of bytes per call to the
+// provided io.Writer and returns a short count with no error for larger writes
+func ShortWriter(writer io.Writer, size int) io.Writer {
+	return shortWriter{writer, size}
+}
+
+type shortWriter struct {
+	writer io.Writer
+	size   int
+}
+
+func (w shortWriter) Write(data []byte) (int, error) {
+	if len(data) > w.size {
+		data = data[:w.size]
+	}
+	return w.writer.Write(data)
+}
@@ -0,0 +1,55 @@
+package iotest // import "github.com/spaskalev/misc/ioutil/iotest"
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+var data []byte = []byte("0123456789ABCDEFGHIJ")
+
+func TestErrorWriter(t *testing.T) {
+	var (
+		fail error = errors.New("Invalid write")
+		buf  bytes.Buffer
+		w    io.Writer = ErrorWriter(&buf, 6, fail)
+	)
+
+	if count, err := w.Write(data[:4]); count != 4 || err != nil {
+		t.Error("Unexpected write", count, err)
+	}
+	if count, err := w.Write(data[4:8]); count != 2 || err != fail {
+		t.Error("Unexpected write over the limit", count, err)
+	}
+	if count, err := w.Write(data[6:]); count != 0 || err != fail {
+		t.Error("Unexpected write after the limit", count, err)
+	}
+	if !bytes.Equal(buf.Bytes(), data[:6]) {
+		t.Error("Unexpected written data", buf.String())
+	}
+
+	// A nil error results in short writes
+	buf.Reset()
+	w = ErrorWriter(&buf, 2, nil)
+	if count, err := w.Write(data); count != 2 || err != nil {
+		t.Error("Unexpected short write", count, err)
+	}
+}
+
+func TestShortWriter(t *testing.T) {
+	var (
+		buf bytes.Buffer
+		w   io.Writer = ShortWriter(&buf, 3)
+	)
+
+	if count, err := w.Write(data[:2]); count != 2 || err != nil {
+		t.Error("Unexpected write", count, err)
+	}
+	if count, err := w.Write(data[2:]); count != 3 || err != nil {
+		t.Error("Unexpected short write", count, err)
+	}
+	if !bytes.Equal(buf.Bytes(), data[:5]) {
+		t.Error("Unexpected written data", buf.String())
+	}
+}
@@ -2,6 +2,7 @@
 package ioutil // import "github.com/spaskalev/misc/ioutil"
 
 import (
+	"errors"
 	"io"
 )
 
@@ -21,82 +22,412 @@ func (r ReaderFunc) Read(b []byte) (int, error) {
 	return r(b)
 }
 
+// An function alias type that implements io.Closer.
+type CloserFunc func() error
+
+// Delegates the call to the CloserFunc while implementing io.Closer.
+func (c CloserFunc) Close() error {
+	return c()
+}
+
+// An function alias type that implements io.ReaderFrom.
+type ReaderFromFunc func(io.Reader) (int64, error)
+
+// Delegates the call to the ReaderFromFunc while implementing io.ReaderFrom.
+func (r ReaderFromFunc) ReadFrom(reader io.Reader) (int64, error) {
+	return r(reader)
+}
+
+// An function alias type that implements io.WriterTo.
+type WriterToFunc func(io.Writer) (int64, error)
+
+// Delegates the call to the WriterToFunc while implementing io.WriterTo.
+func (w WriterToFunc) WriteTo(writer io.Writer) (int64, error) {
+	return w(writer)
+}
+
+// Combines an io.Reader and an io.Closer into an io.ReadCloser.
+type ReadCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// Combines an io.Writer and an io.Closer into an io.WriteCloser.
+type WriteCloser struct {
+	io.Writer
+	io.Closer
+}
+
+// Returns a reader that implements io.WriterTo, so that io.Copy
+// reads from the provided io.Reader with a buffer of the given size,
+// drawn from the shared BufferPool. It does not copy any faster otherwise,
+// the only gain is the choice of the buffer size.
+// Readers that implement io.WriterTo are returned as they are.
+func WithWriterTo(reader io.Reader, size int) io.Reader {
+	if _, ok := reader.(io.WriterTo); ok {
+		return reader
+	}
+	return writerTo{reader, size}
+}
+
+type writerTo struct {
+	reader io.Reader
+	size   int
+}
+
+func (w writerTo) Read(output []byte) (int, error) {
+	return w.reader.Read(output)
+}
+
+func (w writerTo) WriteTo(writer io.Writer) (int64, error) {
+	buffer := GetBuffer(w.size)
+	defer PutBuffer(buffer)
+	return io.CopyBuffer(writer, w.reader, buffer)
+}
+
+// Returns a writer that implements io.ReaderFrom, so that io.Copy
+// writes to the provided io.Writer with a buffer of the given size,
+// drawn from the shared BufferPool. As with WithWriterTo, choosing
+// the buffer size is the only gain over a plain io.Copy.
+// Writers that implement io.ReaderFrom are returned as they are.
+func WithReaderFrom(writer io.Writer, size int) io.Writer {
+	if _, ok := writer.(io.ReaderFrom); ok {
+		return writer
+	}
+	return readerFrom{writer, size}
+}
+
+type readerFrom struct {
+	writer io.Writer
+	size   int
+}
+
+func (r readerFrom) Write(data []byte) (int, error) {
+	return r.writer.Write(data)
+}
+
+func (r readerFrom) ReadFrom(reader io.Reader) (int64, error) {
+	buffer := GetBuffer(r.size)
+	defer PutBuffer(buffer)
+	return io.CopyBuffer(r.writer, reader, buffer)
+}
+
 // Reads a single byte from the provided io.Reader
+//
+// Empty reads are retried and io.ByteReader is used if the reader implements it.
+// Use ByteReader for reading many bytes as this function allocates on each call.
 func ReadByte(reader io.Reader) (byte, error) {
+	if br, ok := reader.(io.ByteReader); ok {
+		return br.ReadByte()
+	}
+
+	var arr [1]byte
+	return readByte(reader, arr[:])
+}
+
+// Reads a single byte into the buffer, retrying empty reads
+func readByte(reader io.Reader, buffer []byte) (byte, error) {
+	for i := 0; i < maxEmptyReads; i++ {
+		count, err := reader.Read(buffer[:1])
+		if count > 0 {
+			return buffer[0], nil
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+	return 0, io.ErrNoProgress
+}
+
+// ErrUnreadByte is returned by UnreadByte when there is no byte to unread
+var ErrUnreadByte = errors.New("ioutil: invalid use of UnreadByte")
+
+// Returns an io.ByteScanner that reads single bytes from the provided io.Reader
+// without reading ahead. Readers that implement io.ByteScanner are returned as they are.
+//
+// Empty reads are retried until a limit after which io.ErrNoProgress is returned.
+// The returned scanner also implements io.Reader.
+func ByteReader(reader io.Reader) io.ByteScanner {
+	if scanner, ok := reader.(io.ByteScanner); ok {
+		return scanner
+	}
+
+	var bs byteScanner
+	bs.reader = reader
+	bs.byteReader, _ = reader.(io.ByteReader)
+	return &bs
+}
+
+type byteScanner struct {
+	reader     io.Reader
+	byteReader io.ByteReader
+	buffer     [1]byte
+
+	// The last read byte, whether it can be unread and whether it was unread
+	last   byte
+	valid  bool
+	unread bool
+}
+
+func (bs *byteScanner) ReadByte() (byte, error) {
+	if bs.unread {
+		bs.unread, bs.valid = false, true
+		return bs.last, nil
+	}
+
 	var (
-		arr [1]byte
-		err error
+		value byte
+		err   error
 	)
-	_, err = reader.Read(arr[:])
-	return arr[0], err
+	if bs.byteReader != nil {
+		value, err = bs.byteReader.ReadByte()
+	} else {
+		value, err = readByte(bs.reader, bs.buffer[:])
+	}
+
+	bs.last, bs.valid = value, err == nil
+	return value, err
 }
 
-// Returns a writer that delegates calls to Write(...) while ensuring
-// that it is never called with less bytes than the specified amount.
-//
-// Calls with fewer bytes are buffered while a call with a nil slice
-// causes the buffer to be flushed to the underlying writer.
-func SizedWriter(writer io.Writer, size int) io.Writer {
-	var sw sizedWriter
-	sw.writer = writer
-	sw.buffer = make([]byte, 0, size)
-	sw.size = size
-	return &sw
+func (bs *byteScanner) UnreadByte() error {
+	if !bs.valid {
+		return ErrUnreadByte
+	}
+	bs.unread, bs.valid = true, false
+	return nil
 }
 
-type sizedWriter struct {
+// Returns the unread byte first, if there is one
+func (bs *byteScanner) Read(output []byte) (int, error) {
+	bs.valid = false
+	if len(output) == 0 {
+		return 0, nil
+	}
+	if bs.unread {
+		bs.unread, output[0] = false, bs.last
+		return 1, nil
+	}
+	return bs.reader.Read(output)
+}
+
+// ErrClosed is returned when writing to a closed BlockWriter
+var ErrClosed = errors.New("ioutil: write to closed writer")
+
+// A BlockWriter buffers the data written to it and writes it to an
+// underlying io.Writer in multiples of a block size. Only Flush and Close
+// write less than a block.
+//
+// Any error from the underlying writer is sticky and returned
+// by all subsequent calls to Write, Flush and Close.
+type BlockWriter struct {
 	writer io.Writer
 	buffer []byte
 	size   int
+	err    error
 }
 
-func (sw *sizedWriter) Write(input []byte) (int, error) {
-	var (
-		count int
-		err   error
-	)
+// Returns a new BlockWriter that writes to the provided io.Writer in blocks of the given size
+func NewBlockWriter(writer io.Writer, size int) *BlockWriter {
+	var bw BlockWriter
+	bw.writer = writer
+	bw.buffer = GetBuffer(size)[:0]
+	bw.size = size
+	return &bw
+}
 
-	// Flush the buffer when called with no bytes to write
-	if input == nil {
-		// Call the writer with whatever we have in store..
-		count, err = sw.writer.Write(sw.buffer)
+// Implements io.Writer
+//
+// The returned count is the number of bytes that the caller does not
+// need to retry: the ones written to the underlying writer and the ones
+// staged in the buffer. On an error the bytes of the input that were staged
+// but not written are dropped from the buffer and are not counted.
+func (bw *BlockWriter) Write(input []byte) (int, error) {
+	var total int
+
+	if bw.err != nil {
+		return 0, bw.err
+	}
+
+	for len(input) > 0 {
+		// Delegate whole blocks to the writer if nothing is buffered
+		if len(bw.buffer) == 0 && len(input) >= bw.size {
+			reduced := (len(input) / bw.size) * bw.size
+			count, err := bw.write(input[:reduced])
+			if total += count; err != nil {
+				return total, err
+			}
+			input = input[reduced:]
+			continue
+		}
+
+		// Append data to the buffer
+		prior := len(bw.buffer)
+		count := copy(bw.buffer[prior:bw.size], input)
+		bw.buffer = bw.buffer[:prior+count]
 
-		// Advance the buffer
-		sw.buffer = sw.buffer[:copy(sw.buffer, sw.buffer[count:])]
+		// Return if we don't have enough bytes to write
+		if len(bw.buffer) < bw.size {
+			return total + count, nil
+		}
 
-		return 0, err
+		// Flush the buffer as it is filled, accounting only
+		// for the staged bytes that reached the writer on an error
+		written, err := bw.flush()
+		if err != nil {
+			if written < prior {
+				bw.buffer = bw.buffer[:prior-written]
+				return total, err
+			}
+			bw.buffer = bw.buffer[:0]
+			return total + written - prior, err
+		}
+		input, total = input[count:], total+count
 	}
 
-	// Delegate to the writer if the size is right
-	if len(sw.buffer) == 0 && len(input) >= sw.size {
-		reduced := (len(input) / sw.size) * sw.size
-		count, err = sw.writer.Write(input[:reduced])
-		if count < reduced || err != nil {
-			return count, err
+	return total, nil
+}
+
+// ErrInvalidWrite is returned when an underlying writer reports
+// a count that is negative or larger than the written data
+var ErrInvalidWrite = errors.New("ioutil: invalid write result")
+
+// Writes data to the provided io.Writer and returns io.ErrShortWrite
+// if less than all of it was written without an error
+func WriteFull(writer io.Writer, data []byte) (int, error) {
+	count, err := writer.Write(data)
+	if err == nil && count < len(data) {
+		err = io.ErrShortWrite
+	}
+	return count, err
+}
+
+// Writes to the underlying writer, treating short writes as errors
+func (bw *BlockWriter) write(data []byte) (int, error) {
+	count, err := WriteFull(bw.writer, data)
+	if count < 0 || count > len(data) {
+		count, err = 0, ErrInvalidWrite
+	}
+	bw.err = err
+	return count, err
+}
+
+// Writes the buffered data to the underlying writer, keeping the data
+// that was not written, and returns the number of written bytes
+func (bw *BlockWriter) flush() (int, error) {
+	count, err := bw.write(bw.buffer)
+	bw.buffer = bw.buffer[:copy(bw.buffer, bw.buffer[count:])]
+	return count, err
+}
+
+// Writes any buffered data to the underlying writer
+func (bw *BlockWriter) Flush() error {
+	if bw.err != nil || len(bw.buffer) == 0 {
+		return bw.err
+	}
+
+	_, err := bw.flush()
+	return err
+}
+
+// Implements io.ReaderFrom by reading directly into the buffer
+//
+// Reading stops at the end of the provided reader, which is not reported
+// as an error. The data that does not fill a block stays in the buffer.
+// The returned count follows the rules of Write.
+func (bw *BlockWriter) ReadFrom(reader io.Reader) (int64, error) {
+	var total int64
+
+	if bw.err != nil {
+		return 0, bw.err
+	}
+
+	for {
+		prior := len(bw.buffer)
+		count, err := reader.Read(bw.buffer[prior:bw.size])
+		bw.buffer = bw.buffer[:prior+count]
+
+		// Flush the buffer as it is filled, accounting only
+		// for the read bytes that reached the writer on an error
+		if len(bw.buffer) == bw.size {
+			written, werr := bw.flush()
+			if werr != nil {
+				if written < prior {
+					bw.buffer = bw.buffer[:prior-written]
+					return total, werr
+				}
+				bw.buffer = bw.buffer[:0]
+				return total + int64(written-prior), werr
+			}
 		}
+		total += int64(count)
 
-		// Stage any remaining data in the buffer
-		sw.buffer = append(sw.buffer, input[count:]...)
-		return len(input), nil
+		if err == io.EOF {
+			return total, nil
+		}
+		if err != nil {
+			return total, err
+		}
 	}
+}
 
-	// Append data to the buffer
-	count = copy(sw.buffer[len(sw.buffer):sw.size], input)
-	sw.buffer = sw.buffer[:len(sw.buffer)+count]
+// Returns the number of bytes that are written to the BlockWriter
+// but not yet to the underlying writer
+func (bw *BlockWriter) Buffered() int {
+	return len(bw.buffer)
+}
 
-	// Return if we don't have enough bytes to write
-	if len(sw.buffer) < sw.size {
-		return len(input), nil
+// Flushes the BlockWriter and prevents further writes.
+// It does not close the underlying writer.
+//
+// The buffer is returned to the shared BufferPool, even if the flush fails.
+func (bw *BlockWriter) Close() error {
+	if bw.err == ErrClosed {
+		return nil
 	}
 
-	// Flush the buffer as it is filled
-	_, err = sw.Write(nil)
+	err := bw.Flush()
+	if bw.buffer != nil {
+		PutBuffer(bw.buffer)
+		bw.buffer = nil
+	}
 	if err != nil {
-		return count, err
+		return err
 	}
 
-	// Handle the rest of the input
-	return sw.Write(input[count:])
+	bw.err = ErrClosed
+	return nil
+}
+
+// Discards any buffered data and errors and makes the BlockWriter
+// write to the provided io.Writer
+func (bw *BlockWriter) Reset(writer io.Writer) {
+	if bw.buffer == nil {
+		bw.buffer = GetBuffer(bw.size)
+	}
+	bw.writer, bw.buffer, bw.err = writer, bw.buffer[:0], nil
+}
+
+// Returns a writer that delegates calls to Write(...) while ensuring
+// that it is never called with less bytes than the specified amount.
+//
+// Calls with fewer bytes are buffered while a call with a nil slice
+// causes the buffer to be flushed to the underlying writer.
+//
+// Deprecated: use a BlockWriter, which flushes with an explicit call to Flush.
+func SizedWriter(writer io.Writer, size int) io.Writer {
+	return sizedWriter{NewBlockWriter(writer, size)}
+}
+
+// Maps the nil-write flush convention of SizedWriter onto a BlockWriter
+type sizedWriter struct {
+	*BlockWriter
+}
+
+func (sw sizedWriter) Write(input []byte) (int, error) {
+	if input == nil {
+		return 0, sw.Flush()
+	}
+	return sw.BlockWriter.Write(input)
 }
 
 // Returns a reader that delegates calls to Read(...) while ensuring
@@ -105,17 +436,68 @@ func (sw *sizedWriter) Write(input []byte) (int, error) {
 func SizedReader(reader io.Reader, size int) io.Reader {
 	var sr sizedReader
 	sr.reader = reader
-	sr.buffer = make([]byte, size)
 	sr.size, sr.from, sr.to = size, 0, 0
 	return &sr
 }
 
+// The buffer of a sizedReader is drawn from the shared BufferPool when it is
+// needed and returned to it when the underlying reader ends or fails
 type sizedReader struct {
 	reader         io.Reader
 	buffer         []byte
 	from, to, size int
 }
 
+// Reads into the empty buffer
+func (sr *sizedReader) fill() error {
+	if sr.buffer == nil {
+		sr.buffer = GetBuffer(sr.size)
+	}
+
+	count, err := sr.reader.Read(sr.buffer)
+	sr.from, sr.to = 0, count
+	return err
+}
+
+// Returns the empty buffer to the pool after an error
+func (sr *sizedReader) release(err error) {
+	if err != nil && sr.buffer != nil {
+		PutBuffer(sr.buffer)
+		sr.buffer = nil
+	}
+}
+
+// Implements io.WriterTo by writing directly from the buffer
+func (sr *sizedReader) WriteTo(writer io.Writer) (int64, error) {
+	var (
+		total int64
+		err   error
+	)
+
+	for {
+		// Write out the buffered data
+		if sr.from < sr.to {
+			count, werr := WriteFull(writer, sr.buffer[sr.from:sr.to])
+			sr.from, total = sr.from+count, total+int64(count)
+			if werr != nil {
+				return total, werr
+			}
+		}
+		sr.from, sr.to = 0, 0
+
+		// Return the read error after writing the data that came with it
+		sr.release(err)
+		if err == io.EOF {
+			return total, nil
+		}
+		if err != nil {
+			return total, err
+		}
+
+		err = sr.fill()
+	}
+}
+
 func (sr *sizedReader) Read(output []byte) (int, error) {
 	var (
 		count int
@@ -134,6 +516,7 @@ start:
 		if sr.from == sr.to {
 			// Reset the buffer
 			sr.from, sr.to = 0, 0
+			sr.release(err)
 
 			return count, err
 		}
@@ -147,16 +530,14 @@ start:
 		return sr.reader.Read(output[:(len(output)/sr.size)*sr.size])
 	}
 
-	// Perform a read into the buffer
-	count, err = sr.reader.Read(sr.buffer)
-
-	// Size the buffer down to the read data size
+	// Perform a read into the buffer, sized down to the read data size,
 	// and restart if we have successfully read some bytes
-	sr.from, sr.to = 0, count
+	err = sr.fill()
 	if sr.to > 0 {
 		goto start
 	}
 
 	// Returning on err/misbehaving noop reader
+	sr.release(err)
 	return 0, err
 }
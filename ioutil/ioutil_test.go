@@ -3,9 +3,13 @@ package ioutil // import "github.com/spaskalev/misc/ioutil"
 import (
 	"bytes"
 	"errors"
+	"fmt"
 	diff "github.com/spaskalev/diff"
+	iot "github.com/spaskalev/misc/ioutil/iotest"
 	"io"
+	"io/ioutil"
 	"testing"
+	"testing/iotest"
 )
 
 func TestWriterFunc(t *testing.T) {
@@ -48,6 +52,106 @@ func TestReaderFunc(t *testing.T) {
 	}
 }
 
+func TestCloserFunc(t *testing.T) {
+	var (
+		closed int
+		closer io.Closer = CloserFunc(func() error {
+			closed++
+			return nil
+		})
+	)
+
+	rc := ReadCloser{bytes.NewReader([]byte{1}), closer}
+	if result, err := ioutil.ReadAll(rc); err != nil || len(result) != 1 {
+		t.Error("Unexpected result from ReadCloser", result, err)
+	}
+	rc.Close()
+
+	var buffer bytes.Buffer
+	wc := WriteCloser{&buffer, closer}
+	if count, err := wc.Write([]byte{1}); count != 1 || err != nil {
+		t.Error("Unexpected result from WriteCloser", count, err)
+	}
+	wc.Close()
+
+	if closed != 2 {
+		t.Error("Unexpected number of calls to CloserFunc", closed)
+	}
+}
+
+func TestReaderFromWriterToFunc(t *testing.T) {
+	var (
+		input  []byte = []byte{0, 1, 2, 3, 4, 5, 6, 7}
+		buffer bytes.Buffer
+	)
+
+	var writerTo io.WriterTo = WriterToFunc(bytes.NewReader(input).WriteTo)
+	count, err := writerTo.WriteTo(&buffer)
+	if count != 8 || err != nil || !bytes.Equal(buffer.Bytes(), input) {
+		t.Error("Unexpected result from WriterToFunc", count, err)
+	}
+
+	buffer.Reset()
+	var readerFrom io.ReaderFrom = ReaderFromFunc(buffer.ReadFrom)
+	count, err = readerFrom.ReadFrom(bytes.NewReader(input))
+	if count != 8 || err != nil || !bytes.Equal(buffer.Bytes(), input) {
+		t.Error("Unexpected result from ReaderFromFunc", count, err)
+	}
+}
+
+// Records the data and the sizes of the calls to it
+type recordingWriter struct {
+	data  bytes.Buffer
+	sizes []int
+}
+
+func (rw *recordingWriter) Write(data []byte) (int, error) {
+	rw.sizes = append(rw.sizes, len(data))
+	return rw.data.Write(data)
+}
+
+func TestWithWriterTo(t *testing.T) {
+	var (
+		input  []byte = make([]byte, 100)
+		target recordingWriter
+	)
+
+	// Readers with io.WriterTo are kept
+	reader := bytes.NewReader(input)
+	if WithWriterTo(reader, 16) != io.Reader(reader) {
+		t.Error("Unexpected wrapping of an io.WriterTo")
+	}
+
+	count, err := io.Copy(&target, WithWriterTo(ReaderFunc(reader.Read), 16))
+	if count != 100 || err != nil || !bytes.Equal(target.data.Bytes(), input) {
+		t.Error("Unexpected result from WithWriterTo", count, err)
+	}
+	if fmt.Sprint(target.sizes) != "[16 16 16 16 16 16 4]" {
+		t.Error("Unexpected write sizes", target.sizes)
+	}
+}
+
+func TestWithReaderFrom(t *testing.T) {
+	var (
+		input  []byte = make([]byte, 100)
+		target recordingWriter
+	)
+
+	// Writers with io.ReaderFrom are kept
+	var buffer bytes.Buffer
+	if WithReaderFrom(&buffer, 16) != io.Writer(&buffer) {
+		t.Error("Unexpected wrapping of an io.ReaderFrom")
+	}
+
+	count, err := io.Copy(WithReaderFrom(&target, 16), ReaderFunc(bytes.NewReader(input).Read))
+	if count != 100 || err != nil || !bytes.Equal(target.data.Bytes(), input) {
+		t.Error("Unexpected result from WithReaderFrom", count, err)
+	}
+	if fmt.Sprint(target.sizes) != "[16 16 16 16 16 16 4]" {
+		t.Error("Unexpected write sizes", target.sizes)
+	}
+}
+
 func TestReadByte(t *testing.T) {
 	var (
 		input  []byte        = []byte{255}
@@ -68,6 +172,143 @@ func TestReadByte(t *testing.T) {
 	}
 }
 
+// Returns a reader that returns a number of empty reads before each byte of the data
+func emptyReader(data []byte, empty int) io.Reader {
+	var calls int
+	return ReaderFunc(func(output []byte) (int, error) {
+		if calls++; calls%(empty+1) != 0 {
+			return 0, nil
+		}
+		if len(data) == 0 {
+			return 0, io.EOF
+		}
+		output[0], data = data[0], data[1:]
+		return 1, nil
+	})
+}
+
+func TestReadByteEmpty(t *testing.T) {
+	reader := emptyReader([]byte{1, 2}, 3)
+	for _, expected := range []byte{1, 2} {
+		if result, err := ReadByte(reader); result != expected || err != nil {
+			t.Error("Unexpected result from ReadByte", result, err)
+		}
+	}
+	if _, err := ReadByte(reader); err != io.EOF {
+		t.Error("Unexpected error from ReadByte", err)
+	}
+
+	if _, err := ReadByte(emptyReader(nil, maxEmptyReads)); err != io.ErrNoProgress {
+		t.Error("Unexpected error for empty reads", err)
+	}
+}
+
+// Implements io.ByteReader but not io.ByteScanner
+type onlyByteReader struct {
+	reader *bytes.Reader
+}
+
+func (r onlyByteReader) Read(output []byte) (int, error) {
+	return r.reader.Read(output)
+}
+
+func (r onlyByteReader) ReadByte() (byte, error) {
+	return r.reader.ReadByte()
+}
+
+func TestByteReader(t *testing.T) {
+	var input []byte = []byte{1, 2, 3}
+
+	// Scanners are used as they are
+	reader := bytes.NewReader(input)
+	if ByteReader(reader) != io.ByteScanner(reader) {
+		t.Error("Unexpected wrapping of an io.ByteScanner")
+	}
+
+	var sources = []struct {
+		name   string
+		reader io.Reader
+	}{
+		{"empty reads", emptyReader(input, 2)},
+		{"one byte", iotest.OneByteReader(bytes.NewReader(input))},
+		{"data with error", iotest.DataErrReader(bytes.NewReader(input))},
+		{"byte reader", onlyByteReader{bytes.NewReader(input)}},
+	}
+
+	for _, source := range sources {
+		var (
+			scanner io.ByteScanner = ByteReader(source.reader)
+			result  []byte
+		)
+
+		if err := scanner.UnreadByte(); err != ErrUnreadByte {
+			t.Error(source.name, "unexpected unread before a read", err)
+		}
+
+		for {
+			value, err := scanner.ReadByte()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatal(source.name, err)
+			}
+
+			// Every byte is unread and read again
+			if err := scanner.UnreadByte(); err != nil {
+				t.Error(source.name, "unexpected error from UnreadByte", err)
+			}
+			if err := scanner.UnreadByte(); err != ErrUnreadByte {
+				t.Error(source.name, "unexpected second unread", err)
+			}
+			if again, err := scanner.ReadByte(); again != value || err != nil {
+				t.Error(source.name, "unexpected byte after unread", again, err)
+			}
+			result = append(result, value)
+		}
+
+		if !bytes.Equal(result, input) {
+			t.Error(source.name, "unexpected result", result)
+		}
+		if err := scanner.UnreadByte(); err != ErrUnreadByte {
+			t.Error(source.name, "unexpected unread after an error", err)
+		}
+	}
+}
+
+func TestByteReaderRead(t *testing.T) {
+	var (
+		scanner io.ByteScanner = ByteReader(iotest.OneByteReader(bytes.NewReader([]byte{1, 2, 3})))
+		output  []byte         = make([]byte, 4)
+	)
+
+	scanner.ReadByte()
+	scanner.UnreadByte()
+
+	// The unread byte is returned by Read first
+	reader := scanner.(io.Reader)
+	if count, err := reader.Read(output); count != 1 || err != nil || output[0] != 1 {
+		t.Error("Unexpected read of the unread byte", count, err, output)
+	}
+	if result, err := ioutil.ReadAll(reader); err != nil || !bytes.Equal(result, []byte{2, 3}) {
+		t.Error("Unexpected result", result, err)
+	}
+	if err := scanner.UnreadByte(); err != ErrUnreadByte {
+		t.Error("Unexpected unread after Read", err)
+	}
+}
+
+func TestByteReaderAllocs(t *testing.T) {
+	var (
+		source  *bytes.Reader  = bytes.NewReader(make([]byte, 1<<10))
+		scanner io.ByteScanner = ByteReader(iotest.OneByteReader(source))
+	)
+
+	if allocs := testing.AllocsPerRun(100, func() { scanner.ReadByte() }); allocs != 0 {
+		t.Error("Unexpected allocations per byte", allocs)
+	}
+}
+
 func TestSizedWriter(t *testing.T) {
 	var (
 		buffer bytes.Buffer
@@ -83,7 +324,7 @@ func TestSizedWriter(t *testing.T) {
 	}
 
 	count, err = writer.Write([]byte("3456"))
-	if count != 2 {
+	if count != 4 {
 		t.Error("Unexpected write count from SizedWriter", count)
 	}
 	if err != nil {
@@ -163,8 +404,9 @@ func TestSizedWriterError1(t *testing.T) {
 		t.Error("Unexpected error from SizedWriter", err)
 	}
 
+	// The written byte is the buffered one so none of the input is accepted
 	count, err = writer.Write([]byte("2"))
-	if count != 1 {
+	if count != 0 {
 		t.Error("Unexpected write count from SizedWriter", count)
 	}
 	if err == nil {
@@ -189,6 +431,249 @@ func TestSizedWriterError2(t *testing.T) {
 	}
 }
 
+func TestSizedWriterEmpty(t *testing.T) {
+	var (
+		buffer bytes.Buffer
+		writer io.Writer = SizedWriter(&buffer, 4)
+	)
+
+	writer.Write([]byte("12"))
+
+	// Only a nil slice flushes the buffer
+	count, err := writer.Write([]byte{})
+	if count != 0 || err != nil {
+		t.Error("Unexpected result from an empty write", count, err)
+	}
+	if buffer.Len() != 0 {
+		t.Error("Unexpected value in wrapped writer", buffer.String())
+	}
+}
+
+func TestBlockWriter(t *testing.T) {
+	var (
+		buffer bytes.Buffer
+		sizes  []int
+		writer *BlockWriter = NewBlockWriter(WriterFunc(func(data []byte) (int, error) {
+			sizes = append(sizes, len(data))
+			return buffer.Write(data)
+		}), 4)
+	)
+
+	for _, input := range []string{"1", "23", "456", "", "789ABCDEF"} {
+		if count, err := writer.Write([]byte(input)); count != len(input) || err != nil {
+			t.Error("Unexpected result from BlockWriter", count, err)
+		}
+	}
+	if buffer.String() != "123456789ABC" {
+		t.Error("Unexpected value in wrapped writer", buffer.String())
+	}
+
+	if err := writer.Flush(); err != nil {
+		t.Error("Unexpected error from Flush", err)
+	}
+	if buffer.String() != "123456789ABCDEF" {
+		t.Error("Unexpected value in wrapped writer", buffer.String())
+	}
+
+	// Only the flush writes less than a block
+	if fmt.Sprint(sizes) != "[4 4 4 3]" {
+		t.Error("Unexpected write sizes", sizes)
+	}
+
+	// Flushing an empty buffer does not call the writer
+	if err := writer.Flush(); err != nil || len(sizes) != 4 {
+		t.Error("Unexpected flush of an empty buffer", err, sizes)
+	}
+}
+
+func TestBlockWriterClose(t *testing.T) {
+	var (
+		buffer bytes.Buffer
+		writer *BlockWriter = NewBlockWriter(&buffer, 4)
+	)
+
+	writer.Write([]byte("12"))
+	if err := writer.Close(); err != nil {
+		t.Error("Unexpected error from Close", err)
+	}
+	if buffer.String() != "12" {
+		t.Error("Unexpected value in wrapped writer", buffer.String())
+	}
+
+	if writer.buffer != nil {
+		t.Error("The buffer is not returned to the pool on Close")
+	}
+
+	if count, err := writer.Write([]byte("3")); count != 0 || err != ErrClosed {
+		t.Error("Unexpected write to a closed BlockWriter", count, err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Error("Unexpected error from a second Close", err)
+	}
+
+	// The writer is usable after a reset
+	buffer.Reset()
+	writer.Reset(&buffer)
+	writer.Write([]byte("345"))
+	writer.Flush()
+	if buffer.String() != "345" {
+		t.Error("Unexpected value after reset", buffer.String())
+	}
+}
+
+func TestBlockWriterSticky(t *testing.T) {
+	var (
+		failure error = errors.New("Invalid write")
+		calls   int
+		writer  *BlockWriter = NewBlockWriter(WriterFunc(func(data []byte) (int, error) {
+			calls++
+			return 0, failure
+		}), 2)
+	)
+
+	writer.Write([]byte("1"))
+	if err := writer.Flush(); err != failure {
+		t.Error("Unexpected error from Flush", err)
+	}
+
+	// Every later call returns the same error without calling the writer
+	if count, err := writer.Write([]byte("12")); count != 0 || err != failure {
+		t.Error("Unexpected result from Write", count, err)
+	}
+	if err := writer.Flush(); err != failure {
+		t.Error("Unexpected error from Flush", err)
+	}
+	if err := writer.Close(); err != failure {
+		t.Error("Unexpected error from Close", err)
+	}
+	if calls != 1 {
+		t.Error("Unexpected calls to the failed writer", calls)
+	}
+}
+
+func TestBlockWriterShort(t *testing.T) {
+	var (
+		buffer bytes.Buffer
+		writer *BlockWriter = NewBlockWriter(WriterFunc(func(data []byte) (int, error) {
+			return buffer.Write(data[:len(data)-1])
+		}), 2)
+	)
+
+	// A short write without an error is reported as io.ErrShortWrite
+	count, err := writer.Write([]byte("1234"))
+	if count != 3 || err != io.ErrShortWrite {
+		t.Error("Unexpected result from a short write", count, err)
+	}
+}
+
+func TestWriteFull(t *testing.T) {
+	var (
+		buffer bytes.Buffer
+		fail   error = errors.New("Invalid write")
+	)
+
+	if count, err := WriteFull(&buffer, []byte("1234")); count != 4 || err != nil || buffer.String() != "1234" {
+		t.Error("Unexpected result from a full write", count, err)
+	}
+	if count, err := WriteFull(iot.ShortWriter(&buffer, 2), []byte("1234")); count != 2 || err != io.ErrShortWrite {
+		t.Error("Unexpected result from a short write", count, err)
+	}
+
+	// Errors from the writer are kept
+	if count, err := WriteFull(iot.ErrorWriter(&buffer, 1, fail), []byte("1234")); count != 1 || err != fail {
+		t.Error("Unexpected result from a failing write", count, err)
+	}
+}
+
+func TestBlockWriterFaults(t *testing.T) {
+	var (
+		data    []byte = []byte("0123456789ABCDEFGHIJKLMNOPQRSTUV")
+		failure error  = errors.New("Invalid write")
+	)
+
+	for _, size := range []int{1, 3, 4, 7, 64} {
+		for _, step := range []int{1, 2, 5, 16, len(data)} {
+			for limit := 0; limit <= len(data); limit++ {
+				for _, fault := range []error{failure, nil} {
+					var (
+						target   bytes.Buffer
+						writer   *BlockWriter = NewBlockWriter(iot.ErrorWriter(&target, limit, fault), size)
+						accepted int
+						err      error
+					)
+
+					for at := 0; at < len(data) && err == nil; at += step {
+						end := at + step
+						if end > len(data) {
+							end = len(data)
+						}
+
+						var count int
+						count, err = writer.Write(data[at:end])
+						if count < 0 || count > end-at || (err == nil && count != end-at) {
+							t.Fatal("Invalid write count", size, step, limit, count, err)
+						}
+						accepted += count
+					}
+					if err == nil {
+						err = writer.Flush()
+					}
+
+					name := fmt.Sprint("size ", size, " step ", step, " limit ", limit, " fault ", fault)
+					written := target.Bytes()
+
+					// The underlying writer receives a prefix of the input
+					if !bytes.Equal(written, data[:len(written)]) {
+						t.Fatal("Unexpected written data for", name, string(written))
+					}
+
+					// The accepted bytes are either written or buffered
+					if accepted != len(written)+writer.Buffered() {
+						t.Fatal("Unexpected accepted count for", name, accepted, len(written), writer.Buffered())
+					}
+
+					if limit == len(data) {
+						if err != nil || len(written) != len(data) {
+							t.Fatal("Unexpected failure for", name, err)
+						}
+						continue
+					}
+
+					// The error is reported and is sticky
+					expected := fault
+					if expected == nil {
+						expected = io.ErrShortWrite
+					}
+					if err != expected {
+						t.Fatal("Unexpected error for", name, err)
+					}
+					if count, err := writer.Write(data); count != 0 || err != expected {
+						t.Fatal("Unexpected write after a failure for", name, count, err)
+					}
+					if err := writer.Close(); err != expected {
+						t.Fatal("Unexpected close after a failure for", name, err)
+					}
+					if target.Len() != len(written) {
+						t.Fatal("Unexpected write to a failed writer for", name)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestBlockWriterInvalid(t *testing.T) {
+	for _, result := range []int{-1, 5} {
+		writer := NewBlockWriter(WriterFunc(func(data []byte) (int, error) {
+			return result, nil
+		}), 2)
+
+		if count, err := writer.Write([]byte("1234")); count != 0 || err != ErrInvalidWrite {
+			t.Error("Unexpected result for an invalid count", result, count, err)
+		}
+	}
+}
+
 func TestSizedReader(t *testing.T) {
 	var (
 		input  []byte = []byte{0, 1, 2, 3, 4, 5, 6, 7}
@@ -233,4 +718,71 @@ func TestSizedReader(t *testing.T) {
 	if err != io.EOF {
 		t.Error("Unexpected error from SizedReader", err)
 	}
+	if min.(*sizedReader).buffer != nil {
+		t.Error("The buffer is not returned to the pool at the end")
+	}
+}
+
+func TestSizedReaderWriteTo(t *testing.T) {
+	var (
+		input  []byte = []byte("0123456789ABCDEFGHIJ")
+		target recordingWriter
+		reader io.Reader = SizedReader(iotest.DataErrReader(bytes.NewReader(input)), 8)
+		output []byte    = make([]byte, 2)
+	)
+
+	// Buffered data is written first
+	reader.Read(output)
+
+	count, err := io.Copy(&target, reader)
+	if count != 18 || err != nil || target.data.String() != string(input[2:]) {
+		t.Error("Unexpected result from WriteTo", count, err, target.data.String())
+	}
+	if fmt.Sprint(target.sizes) != "[6 8 4]" {
+		t.Error("Unexpected write sizes", target.sizes)
+	}
+
+	// Write errors are returned
+	fail := errors.New("Invalid write")
+	reader = SizedReader(bytes.NewReader(input), 8)
+	if count, err := io.Copy(iot.ErrorWriter(&target, 10, fail), reader); count != 10 || err != fail {
+		t.Error("Unexpected result from a failing WriteTo", count, err)
+	}
+}
+
+func TestBlockWriterReadFrom(t *testing.T) {
+	var (
+		input  []byte = []byte("0123456789ABCDEFGHIJ")
+		target recordingWriter
+		writer *BlockWriter = NewBlockWriter(&target, 8)
+	)
+
+	writer.Write(input[:2])
+	count, err := io.Copy(writer, iotest.OneByteReader(bytes.NewReader(input[2:])))
+	if count != 18 || err != nil {
+		t.Error("Unexpected result from ReadFrom", count, err)
+	}
+	writer.Flush()
+	if target.data.String() != string(input) || fmt.Sprint(target.sizes) != "[8 8 4]" {
+		t.Error("Unexpected written data", target.data.String(), target.sizes)
+	}
+
+	// The accepted bytes are either written or buffered on failures at every offset
+	fail := errors.New("Invalid write")
+	for limit := 0; limit < len(input); limit++ {
+		var buffer bytes.Buffer
+		writer = NewBlockWriter(iot.ErrorWriter(&buffer, limit, fail), 3)
+		writer.Write(input[:1])
+
+		count, err := writer.ReadFrom(iotest.HalfReader(bytes.NewReader(input[1:])))
+		if err == nil {
+			err = writer.Flush()
+		}
+		if err != fail {
+			t.Error("Unexpected error for limit", limit, err)
+		}
+		if int(count)+1 != buffer.Len()+writer.Buffered() || !bytes.HasPrefix(input, buffer.Bytes()) {
+			t.Error("Unexpected accounting for limit", limit, count, buffer.Len(), writer.Buffered())
+		}
+	}
 }
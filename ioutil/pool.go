@@ -0,0 +1,83 @@
+package ioutil // import "github.com/spaskalev/misc/ioutil"
+
+import (
+	"math/bits"
+	"sync"
+)
+
+// The smallest and the largest size class of a BufferPool, as powers of two
+const (
+	minBufferBits = 6
+	maxBufferBits = 24
+)
+
+// A BufferPool keeps byte slices for reuse in power of two size classes,
+// from 64 bytes to 16 MiB. Larger slices are allocated as needed and dropped.
+//
+// It is safe for concurrent use and its zero value is ready to use.
+type BufferPool struct {
+	classes [maxBufferBits - minBufferBits + 1]sync.Pool
+
+	// The pools keep pointers to slices, which are reused
+	// so that returning a slice does not allocate
+	holders sync.Pool
+}
+
+// Returns the size class for the given size, or -1 if it is too large
+func sizeClass(size int) int {
+	if size <= 1<<minBufferBits {
+		return 0
+	}
+	if size > 1<<maxBufferBits {
+		return -1
+	}
+	return bits.Len(uint(size-1)) - minBufferBits
+}
+
+// Returns a slice of the given length and undefined contents,
+// with the capacity of its size class
+func (p *BufferPool) Get(size int) []byte {
+	class := sizeClass(size)
+	if class < 0 {
+		return make([]byte, size)
+	}
+
+	if holder, ok := p.classes[class].Get().(*[]byte); ok {
+		buffer := *holder
+		*holder = nil
+		p.holders.Put(holder)
+		return buffer[:size]
+	}
+	return make([]byte, size, 1<<uint(class+minBufferBits))
+}
+
+// Returns a slice to the pool for reuse. Slices with a capacity
+// other than that of a size class are dropped.
+func (p *BufferPool) Put(buffer []byte) {
+	class := sizeClass(cap(buffer))
+	if class < 0 || cap(buffer) != 1<<uint(class+minBufferBits) {
+		return
+	}
+
+	holder, ok := p.holders.Get().(*[]byte)
+	if !ok {
+		holder = new([]byte)
+	}
+	*holder = buffer[:cap(buffer)]
+	p.classes[class].Put(holder)
+}
+
+// The pool behind GetBuffer and PutBuffer
+var buffers BufferPool
+
+// Returns a slice of the given length and undefined contents
+// from the package's shared BufferPool
+func GetBuffer(size int) []byte {
+	return buffers.Get(size)
+}
+
+// Returns a slice to the package's shared BufferPool for reuse.
+// The slice must not be used afterwards.
+func PutBuffer(buffer []byte) {
+	buffers.Put(buffer)
+}
@@ -0,0 +1,61 @@
+package ioutil // import "github.com/spaskalev/misc/ioutil"
+
+import (
+	"testing"
+)
+
+func TestBufferPoolClasses(t *testing.T) {
+	var pool BufferPool
+
+	var cases = []struct {
+		size, capacity int
+	}{
+		{0, 64},
+		{1, 64},
+		{64, 64},
+		{65, 128},
+		{4096, 4096},
+		{5000, 8192},
+		{1 << 24, 1 << 24},
+		{1<<24 + 1, 1<<24 + 1},
+	}
+
+	for _, c := range cases {
+		buffer := pool.Get(c.size)
+		if len(buffer) != c.size || cap(buffer) != c.capacity {
+			t.Error("Unexpected buffer for size", c.size, len(buffer), cap(buffer))
+		}
+		pool.Put(buffer)
+	}
+}
+
+func TestBufferPoolReuse(t *testing.T) {
+	var pool BufferPool
+
+	// A buffer of the same class is reused with its length adjusted.
+	// The race detector makes the pool drop random buffers, so try a few times.
+	var reused bool
+	for i := 0; i < 100 && !reused; i++ {
+		buffer := pool.Get(100)
+		pool.Put(buffer[:10])
+		next := pool.Get(128)
+		reused = len(next) == 128 && &next[0] == &buffer[0]
+	}
+	if !reused {
+		t.Error("The buffer is not reused")
+	}
+
+	// Slices with other capacities are dropped
+	pool.Put(make([]byte, 100))
+	if other := pool.Get(100); cap(other) != 128 {
+		t.Error("Unexpected capacity", cap(other))
+	}
+}
+
+func TestBufferPoolAllocs(t *testing.T) {
+	var pool BufferPool
+
+	if allocs := testing.AllocsPerRun(100, func() { pool.Put(pool.Get(1000)) }); allocs > 0 {
+		t.Error("Unexpected allocations", allocs)
+	}
+}
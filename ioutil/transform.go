@@ -0,0 +1,140 @@
+package ioutil // import "github.com/spaskalev/misc/ioutil"
+
+import (
+	"io"
+)
+
+// A Transform is a stream transformation, such as one direction of a codec,
+// that can be applied on either side of a copy.
+type Transform interface {
+	// Returns a reader of the transformed data of the provided io.Reader.
+	// Closing it releases its resources but does not close the provided reader.
+	NewReader(io.Reader) (io.ReadCloser, error)
+
+	// Returns a writer that transforms the data written to it and writes the result
+	// to the provided io.Writer. Closing it flushes the transformed data
+	// but does not close the provided writer.
+	NewWriter(io.Writer) (io.WriteCloser, error)
+}
+
+// A ReaderTransform is a Transform implemented by a reader constructor.
+// It is used on the writing side through an io.Pipe and a separate goroutine.
+type ReaderTransform func(io.Reader) (io.Reader, error)
+
+// Implements Transform, closing readers that implement io.Closer
+func (t ReaderTransform) NewReader(reader io.Reader) (io.ReadCloser, error) {
+	result, err := t(reader)
+	if err != nil {
+		return nil, err
+	}
+	if closer, ok := result.(io.ReadCloser); ok {
+		return closer, nil
+	}
+	return ReadCloser{result, CloserFunc(func() error { return nil })}, nil
+}
+
+// Implements Transform. The transforming reader is created on the goroutine,
+// so its errors, including the ones of its creation, are returned by Write and Close.
+func (t ReaderTransform) NewWriter(writer io.Writer) (io.WriteCloser, error) {
+	var (
+		source, sink = io.Pipe()
+		done         = make(chan error, 1)
+	)
+
+	go func() {
+		reader, err := t.NewReader(source)
+		if err == nil {
+			_, err = io.Copy(writer, reader)
+			if cerr := reader.Close(); err == nil {
+				err = cerr
+			}
+		}
+
+		// Fail any further writes
+		source.CloseWithError(err)
+		done <- err
+	}()
+
+	return WriteCloser{sink, CloserFunc(func() error {
+		sink.Close()
+		return <-done
+	})}, nil
+}
+
+// A WriterTransform is a Transform implemented by a writer constructor.
+// It is used on the reading side through an io.Pipe and a separate goroutine.
+type WriterTransform func(io.Writer) (io.WriteCloser, error)
+
+// Implements Transform
+func (t WriterTransform) NewWriter(writer io.Writer) (io.WriteCloser, error) {
+	return t(writer)
+}
+
+// Implements Transform. The transforming writer is created on the goroutine,
+// so its errors, including the ones of its creation, are returned by Read.
+// Closing the reader stops the goroutine after its current read.
+func (t WriterTransform) NewReader(reader io.Reader) (io.ReadCloser, error) {
+	source, sink := io.Pipe()
+
+	go func() {
+		writer, err := t(sink)
+		if err == nil {
+			_, err = io.Copy(writer, reader)
+			if cerr := writer.Close(); err == nil {
+				err = cerr
+			}
+		}
+		sink.CloseWithError(err)
+	}()
+
+	return source, nil
+}
+
+// Returns a Transform that applies the given ones in order,
+// so that the data passes through the first one first
+// on both the reading and the writing side.
+func Pipeline(transforms ...Transform) Transform {
+	return pipeline(transforms)
+}
+
+type pipeline []Transform
+
+// Implements Transform, closing the readers from the last one to the first
+func (p pipeline) NewReader(reader io.Reader) (io.ReadCloser, error) {
+	var closers []io.Closer
+	for _, t := range p {
+		next, err := t.NewReader(reader)
+		if err != nil {
+			closeAll(closers)
+			return nil, err
+		}
+		reader, closers = next, append([]io.Closer{next}, closers...)
+	}
+	return ReadCloser{reader, CloserFunc(func() error { return closeAll(closers) })}, nil
+}
+
+// Implements Transform, closing the writers from the first one to the last,
+// so that each flushes into the next
+func (p pipeline) NewWriter(writer io.Writer) (io.WriteCloser, error) {
+	var closers []io.Closer
+	for i := len(p) - 1; i >= 0; i-- {
+		next, err := p[i].NewWriter(writer)
+		if err != nil {
+			closeAll(closers)
+			return nil, err
+		}
+		writer, closers = next, append([]io.Closer{next}, closers...)
+	}
+	return WriteCloser{writer, CloserFunc(func() error { return closeAll(closers) })}, nil
+}
+
+// Closes all of the closers in order and returns the first error
+func closeAll(closers []io.Closer) error {
+	var result error
+	for _, closer := range closers {
+		if err := closer.Close(); err != nil && result == nil {
+			result = err
+		}
+	}
+	return result
+}
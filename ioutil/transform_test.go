@@ -0,0 +1,116 @@
+package ioutil // import "github.com/spaskalev/misc/ioutil"
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/ioutil"
+	"testing"
+)
+
+var (
+	// A reader-side transform that upper-cases ASCII letters
+	upper Transform = ReaderTransform(func(reader io.Reader) (io.Reader, error) {
+		return ReaderFunc(func(output []byte) (int, error) {
+			count, err := reader.Read(output)
+			copy(output, bytes.ToUpper(output[:count]))
+			return count, err
+		}), nil
+	})
+
+	// A writer-side transform that hex-encodes in blocks
+	hexer Transform = WriterTransform(func(writer io.Writer) (io.WriteCloser, error) {
+		block := NewBlockWriter(writer, 7)
+		return WriteCloser{hex.NewEncoder(block), CloserFunc(block.Flush)}, nil
+	})
+)
+
+// Transforms the data on the reading side
+func transformReader(t Transform, data []byte) ([]byte, error) {
+	reader, err := t.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return ioutil.ReadAll(reader)
+}
+
+// Transforms the data on the writing side
+func transformWriter(t Transform, data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	writer, err := t.NewWriter(&buf)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := writer.Write(data); err != nil {
+		writer.Close()
+		return buf.Bytes(), err
+	}
+	err = writer.Close()
+	return buf.Bytes(), err
+}
+
+func TestPipeline(t *testing.T) {
+	var (
+		data     []byte = []byte("Lorem ipsum dolor sit amet")
+		expected []byte = []byte(hex.EncodeToString(bytes.ToUpper(data)))
+	)
+
+	for _, p := range []Transform{Pipeline(upper, hexer), Pipeline(Pipeline(upper), Pipeline(), hexer)} {
+		if result, err := transformReader(p, data); err != nil || !bytes.Equal(result, expected) {
+			t.Errorf("Unexpected reader result %q %v", result, err)
+		}
+		if result, err := transformWriter(p, data); err != nil || !bytes.Equal(result, expected) {
+			t.Errorf("Unexpected writer result %q %v", result, err)
+		}
+	}
+
+	// The order of the transforms matters
+	if result, _ := transformReader(Pipeline(hexer, upper), data); !bytes.Equal(result, bytes.ToUpper([]byte(hex.EncodeToString(data)))) {
+		t.Errorf("Unexpected result of the reversed pipeline %q", result)
+	}
+}
+
+func TestPipelineErrors(t *testing.T) {
+	var (
+		fail    error     = errors.New("Invalid transform")
+		failing Transform = ReaderTransform(func(io.Reader) (io.Reader, error) { return nil, fail })
+		broken  Transform = WriterTransform(func(io.Writer) (io.WriteCloser, error) { return nil, fail })
+	)
+
+	// Errors from the native side are returned at once
+	if _, err := Pipeline(upper, failing).NewReader(bytes.NewReader(nil)); err != fail {
+		t.Error("Unexpected error from NewReader", err)
+	}
+	if _, err := Pipeline(broken, upper).NewWriter(ioutil.Discard); err != fail {
+		t.Error("Unexpected error from NewWriter", err)
+	}
+
+	// and from the other side when the data is transformed
+	if _, err := transformReader(Pipeline(upper, broken), []byte("data")); err != fail {
+		t.Error("Unexpected error from Read", err)
+	}
+	if _, err := transformWriter(Pipeline(failing, hexer), []byte("data")); err != fail {
+		t.Error("Unexpected error from Write", err)
+	}
+}
+
+func TestPipelineClose(t *testing.T) {
+	// Closing a reader stops its goroutine
+	reader, err := hexer.NewReader(ReaderFunc(func(output []byte) (int, error) {
+		return len(output), nil
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := reader.Read(make([]byte, 10)); err != nil {
+		t.Error("Unexpected error from Read", err)
+	}
+	if err := reader.Close(); err != nil {
+		t.Error("Unexpected error from Close", err)
+	}
+	if _, err := reader.Read(make([]byte, 10)); err != io.ErrClosedPipe {
+		t.Error("Unexpected error after Close", err)
+	}
+}
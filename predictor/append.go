@@ -0,0 +1,75 @@
+package predictor // import "github.com/spaskalev/misc/predictor"
+
+import (
+	"sync"
+)
+
+// A Context holds the predictor's guess table for compressing or decompressing
+// a sequence of messages with the Append functions without allocating.
+//
+// The guess table is carried over from one message to the next, so messages
+// must be decompressed in the order they were compressed, by a Context
+// with the same options. Reset starts a new sequence.
+type Context struct {
+	context
+}
+
+// Returns a new Context with the given options
+func NewContext(opts Options) (*Context, error) {
+	if err := opts.check(); err != nil {
+		return nil, err
+	}
+
+	var c Context
+	c.init(opts)
+	return &c, nil
+}
+
+// Compresses src, appending the result to dst and returning the extended slice
+func (c *Context) AppendCompress(dst []byte, src []byte) []byte {
+	return c.compress(dst, src)
+}
+
+// Decompresses src, appending the result to dst and returning the extended slice.
+// Returns io.ErrUnexpectedEOF along with the data decompressed so far
+// if the last block of src is truncated.
+func (c *Context) AppendDecompress(dst []byte, src []byte) ([]byte, error) {
+	return c.decompress(dst, src)
+}
+
+// Clears the guess table
+func (c *Context) Reset() {
+	c.reset()
+}
+
+// Contexts with the default options for the package-level Append functions
+var contexts = sync.Pool{
+	New: func() interface{} {
+		c, _ := NewContext(Options{})
+		return c
+	},
+}
+
+// Compresses src as a standalone message with the default options,
+// appending the result to dst and returning the extended slice.
+// Clearing the guess table dominates the cost for small messages,
+// use a Context to compress a sequence of them.
+func AppendCompress(dst []byte, src []byte) []byte {
+	c := contexts.Get().(*Context)
+	c.Reset()
+	dst = c.AppendCompress(dst, src)
+	contexts.Put(c)
+	return dst
+}
+
+// Decompresses src as a standalone message with the default options,
+// appending the result to dst and returning the extended slice.
+// Returns io.ErrUnexpectedEOF along with the data decompressed so far
+// if the last block of src is truncated.
+func AppendDecompress(dst []byte, src []byte) ([]byte, error) {
+	c := contexts.Get().(*Context)
+	c.Reset()
+	dst, err := c.AppendDecompress(dst, src)
+	contexts.Put(c)
+	return dst, err
+}
@@ -0,0 +1,187 @@
+package predictor // import "github.com/spaskalev/misc/predictor"
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"testing"
+)
+
+func TestAppendSample(t *testing.T) {
+	result := AppendCompress([]byte{0xff}, input)
+	if !bytes.Equal(result, append([]byte{0xff}, output...)) {
+		t.Errorf("Unexpected compressed output %#x", result)
+	}
+
+	result, err := AppendDecompress([]byte{0xff}, output)
+	if err != nil || !bytes.Equal(result, append([]byte{0xff}, input...)) {
+		t.Errorf("Unexpected decompressed output %#x %v", result, err)
+	}
+
+	// A truncated last block
+	if _, err := AppendDecompress(nil, output[:15]); err != io.ErrUnexpectedEOF {
+		t.Error("Unexpected error for a truncated message", err)
+	}
+}
+
+func TestAppendContext(t *testing.T) {
+	var (
+		messages   [][]byte
+		compressed [][]byte
+		data       []byte = textCorpus(1 << 12)
+	)
+
+	for i := 0; i < len(data); i += 61 {
+		end := i + 61
+		if end > len(data) {
+			end = len(data)
+		}
+		messages = append(messages, data[i:end])
+	}
+
+	compressor, err := NewContext(Options{TableBits: 12, Hash: Order3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, message := range messages {
+		compressed = append(compressed, compressor.AppendCompress(nil, message))
+	}
+
+	decompressor, _ := NewContext(Options{TableBits: 12, Hash: Order3})
+	for i, message := range compressed {
+		result, err := decompressor.AppendDecompress(nil, message)
+		if err != nil || !bytes.Equal(result, messages[i]) {
+			t.Error("Unexpected result for message", i, err)
+		}
+	}
+
+	// A reset context starts a new sequence
+	compressor.Reset()
+	if result := compressor.AppendCompress(nil, messages[0]); !bytes.Equal(result, compressed[0]) {
+		t.Errorf("Unexpected compressed output after reset %#x", result)
+	}
+
+	if _, err := NewContext(Options{TableBits: 1}); err != ErrOptions {
+		t.Error("Unexpected error for invalid options", err)
+	}
+}
+
+func TestAppendAllocs(t *testing.T) {
+	var (
+		compressed   []byte = make([]byte, 0, 1024)
+		decompressed []byte = make([]byte, 0, 1024)
+		c, _                = NewContext(Options{})
+	)
+
+	// Warm up the pool
+	AppendDecompress(decompressed, AppendCompress(compressed, input))
+
+	for name, f := range map[string]func(){
+		"AppendCompress":   func() { AppendCompress(compressed, input) },
+		"AppendDecompress": func() { AppendDecompress(decompressed, output) },
+		"Context.AppendCompress": func() {
+			c.AppendCompress(compressed, input)
+		},
+		"Context.AppendDecompress": func() {
+			c.Reset()
+			c.AppendDecompress(decompressed, output)
+		},
+	} {
+		if allocs := testing.AllocsPerRun(100, f); allocs > 0 {
+			t.Error("Unexpected allocations for", name, allocs)
+		}
+	}
+}
+
+func TestStreamAllocs(t *testing.T) {
+	var (
+		w *Writer = NewWriter(ioutil.Discard)
+		r *Reader = NewReader(bytes.NewReader(nil))
+
+		compressed bytes.Reader
+		buffer     []byte = make([]byte, 100)
+	)
+
+	if allocs := testing.AllocsPerRun(100, func() { w.Write(input[:13]) }); allocs > 0 {
+		t.Error("Unexpected allocations for Writer.Write", allocs)
+	}
+
+	if allocs := testing.AllocsPerRun(100, func() {
+		compressed.Reset(output)
+		r.Reset(&compressed)
+		r.Read(buffer)
+	}); allocs > 0 {
+		t.Error("Unexpected allocations for Reader.Read", allocs)
+	}
+}
+
+// Compresses many small records, reporting the allocations
+func BenchmarkAppendCompress(b *testing.B) {
+	var (
+		records []byte = binaryCorpus(1 << 16)
+		dst     []byte = make([]byte, 0, 64)
+	)
+
+	b.ReportAllocs()
+	b.SetBytes(32)
+	for i := 0; i < b.N; i++ {
+		at := i * 32 % len(records)
+		dst = AppendCompress(dst[:0], records[at:at+32])
+	}
+}
+
+// Compresses many small records with a shared context, reporting the allocations
+func BenchmarkContextAppendCompress(b *testing.B) {
+	var (
+		records []byte = binaryCorpus(1 << 16)
+		dst     []byte = make([]byte, 0, 64)
+		c, _           = NewContext(Options{})
+	)
+
+	b.ReportAllocs()
+	b.SetBytes(32)
+	for i := 0; i < b.N; i++ {
+		at := i * 32 % len(records)
+		dst = c.AppendCompress(dst[:0], records[at:at+32])
+	}
+}
+
+// Decompresses many small records with a shared context, reporting the allocations
+func BenchmarkContextAppendDecompress(b *testing.B) {
+	var (
+		records    []byte = binaryCorpus(1 << 16)
+		compressed [][]byte
+		dst        []byte = make([]byte, 0, 64)
+		c, _              = NewContext(Options{})
+	)
+
+	for at := 0; at < len(records); at += 32 {
+		compressed = append(compressed, c.AppendCompress(nil, records[at:at+32]))
+	}
+	c.Reset()
+
+	b.ReportAllocs()
+	b.SetBytes(32)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if i%len(compressed) == 0 {
+			c.Reset()
+		}
+		dst, _ = c.AppendDecompress(dst[:0], compressed[i%len(compressed)])
+	}
+}
+
+// Writes many small records through a Writer, reporting the allocations
+func BenchmarkWriterSmall(b *testing.B) {
+	var (
+		records []byte  = binaryCorpus(1 << 16)
+		w       *Writer = NewWriter(ioutil.Discard)
+	)
+
+	b.ReportAllocs()
+	b.SetBytes(13)
+	for i := 0; i < b.N; i++ {
+		at := i * 13 % (len(records) - 13)
+		w.Write(records[at : at+13])
+	}
+}
@@ -0,0 +1,153 @@
+package predictor // import "github.com/spaskalev/misc/predictor"
+
+import (
+	iou "github.com/spaskalev/misc/ioutil"
+	"io"
+)
+
+// The size of the buffer over the source of a Reader or a FrameReader
+const sourceSize = 4096
+
+// The number of consecutive empty reads after which reading fails with io.ErrNoProgress
+const maxEmptyReads = 100
+
+// Returns the largest compressed size of the given amount of plain data,
+// as every block of 8 bytes takes at most maxBlockSize bytes
+func packedSize(length int) int {
+	return length + (length+7)/8*(maxBlockSize-8)
+}
+
+// Returns an empty buffer with at least the given capacity. The provided buffer
+// is returned to the shared BufferPool and replaced from it if it is too small.
+func reserve(buffer []byte, size int) []byte {
+	if cap(buffer) >= size {
+		return buffer[:0]
+	}
+	putBuffer(&buffer)
+	return iou.GetBuffer(size)[:0]
+}
+
+// Appends data to the buffer, moving it to a larger one from the shared BufferPool
+// if it is too small. The size classes of the pool double the capacity as it grows.
+func appendBuffer(buffer []byte, data []byte) []byte {
+	if len(buffer)+len(data) > cap(buffer) {
+		grown := iou.GetBuffer(len(buffer) + len(data))[:len(buffer)]
+		copy(grown, buffer)
+		putBuffer(&buffer)
+		buffer = grown
+	}
+	return append(buffer, data...)
+}
+
+// Returns the buffer, if any, to the shared BufferPool and clears the reference to it
+func putBuffer(buffer *[]byte) {
+	if *buffer != nil {
+		iou.PutBuffer(*buffer)
+		*buffer = nil
+	}
+}
+
+// A buffered io.ByteReader over the source of a Reader or a FrameReader
+// that keeps track of the amount of consumed data. Its buffer is drawn
+// from the shared BufferPool when reading and returned to it by release.
+//
+// Errors of the source are returned once, after the data read before them.
+type sourceReader struct {
+	reader   io.Reader
+	buffer   []byte
+	from, to int
+	err      error
+	offset   int64
+}
+
+// Discards the buffered data and starts reading from the provided io.Reader
+func (s *sourceReader) reset(reader io.Reader) {
+	s.reader, s.from, s.to, s.err, s.offset = reader, 0, 0, nil, 0
+}
+
+// Discards the buffered data and returns the buffer to the pool
+func (s *sourceReader) release() {
+	putBuffer(&s.buffer)
+	s.from, s.to = 0, 0
+}
+
+// Reads into the empty buffer, retrying empty reads
+func (s *sourceReader) fill() {
+	if s.buffer == nil {
+		s.buffer = iou.GetBuffer(sourceSize)
+	}
+
+	s.from, s.to = 0, 0
+	for i := 0; s.to == 0 && s.err == nil; i++ {
+		if i == maxEmptyReads {
+			s.err = io.ErrNoProgress
+			break
+		}
+		s.to, s.err = s.reader.Read(s.buffer)
+	}
+}
+
+// Returns the error of the source and clears it
+func (s *sourceReader) error() error {
+	err := s.err
+	s.err = nil
+	return err
+}
+
+// Returns the amount of buffered data
+func (s *sourceReader) buffered() int {
+	return s.to - s.from
+}
+
+// Returns the buffered data, which is valid until the next read
+func (s *sourceReader) peek() []byte {
+	return s.buffer[s.from:s.to]
+}
+
+// Consumes up to the amount of buffered data
+func (s *sourceReader) discard(count int) {
+	s.from += count
+	s.offset += int64(count)
+}
+
+// Implements io.Reader. Reads of at least the size of the buffer
+// bypass it when it is empty.
+func (s *sourceReader) Read(output []byte) (int, error) {
+	if len(output) == 0 {
+		return 0, nil
+	}
+
+	if s.from == s.to {
+		if s.err != nil {
+			return 0, s.error()
+		}
+		if len(output) >= sourceSize {
+			count, err := s.reader.Read(output)
+			s.offset += int64(count)
+			return count, err
+		}
+		if s.fill(); s.from == s.to {
+			return 0, s.error()
+		}
+	}
+
+	count := copy(output, s.buffer[s.from:s.to])
+	s.discard(count)
+	return count, nil
+}
+
+// Implements io.ByteReader
+func (s *sourceReader) ReadByte() (byte, error) {
+	if s.from == s.to {
+		if s.err != nil {
+			return 0, s.error()
+		}
+		if s.fill(); s.from == s.to {
+			return 0, s.error()
+		}
+	}
+
+	value := s.buffer[s.from]
+	s.discard(1)
+	return value, nil
+}
@@ -0,0 +1,251 @@
+package predictor // import "github.com/spaskalev/misc/predictor"
+
+// In candidates mode every slot of the guess table keeps several guesses,
+// ordered from the most to the least recently used. A byte that matches the
+// first guess is encoded as a raised flag, as in RFC1978. Every other byte
+// is encoded as a code, which is followed by the byte itself if it does not
+// match any of the other guesses.
+//
+//	block: flags (1 byte), code bytes (if needed), mispredicted bytes
+//	codes: 0 - mispredicted, 1 - second guess (2 candidates)
+//	       0 - mispredicted, 10 - second, 110 - third, 111 - fourth guess (4 candidates)
+//
+// Codes are packed least significant bits first into code bytes that are shared
+// by a group of 8 blocks. A block starts a code byte when the previous one is full
+// and the last code byte of a group is padded with zeros.
+//
+// A mispredicted byte becomes the first guess of its slot, evicting the last one,
+// and a predicted byte is moved to the front of its slot.
+//
+// The length of a partial block is the largest one whose code bytes and mispredicted
+// bytes add up to the block's size, as the padding decodes as mispredicted bytes.
+
+const (
+	// The maximum size of a compressed block of 8 bytes
+	maxBlockSize = 10
+
+	// The number of blocks that share code bytes
+	groupBlocks = 8
+)
+
+// Returns the number of guesses per slot, applying the default
+func (o Options) candidates() int {
+	if o.Candidates == 0 {
+		return 1
+	}
+	return o.Candidates
+}
+
+// Returns the size of the guess table, in bytes
+func (o Options) tableSize() int {
+	return o.candidates() << o.tableBits()
+}
+
+// The state of the code bytes of a group
+type codes struct {
+	// The current code byte, the number of its bits that are used
+	// and its offset in the output when compressing
+	value byte
+	used  uint
+	at    int
+
+	// The number of blocks in the group
+	blocks int
+}
+
+// Starts a new group
+func (c *codes) reset() {
+	*c = codes{used: 8}
+}
+
+// Accounts for a block, starting a new group after the last one
+func (c *codes) block() {
+	if c.blocks++; c.blocks == groupBlocks {
+		c.reset()
+	}
+}
+
+// Returns the amount of data that is compressed at once by a Writer,
+// which is a group of blocks in candidates mode
+func (ctx *context) unit() int {
+	if ctx.candidates > 1 {
+		return groupBlocks * 8
+	}
+	return 8
+}
+
+// Returns the bit at the position of the code bits that are left in the current
+// code byte followed by data. Returns false if data ends before the position.
+func (ctx *context) bit(data []byte, position uint) (byte, bool) {
+	var available uint = 8 - ctx.codes.used
+	if position < available {
+		return ctx.codes.value >> (ctx.codes.used + position) & 1, true
+	}
+	position -= available
+
+	if int(position/8) >= len(data) {
+		return 0, false
+	}
+	return data[position/8] >> (position % 8) & 1, true
+}
+
+// Returns the number of code bytes from data that hold the bits up to the position
+func (ctx *context) codeBytes(position uint) int {
+	if available := 8 - ctx.codes.used; position > available {
+		return int(position-available+7) / 8
+	}
+	return 0
+}
+
+// Reads the code at the bit position. Returns the index of the matching guess,
+// zero for a mispredicted byte, and the position after the code.
+// Returns false if data ends before the code.
+func (ctx *context) code(data []byte, position uint) (index int, next uint, ok bool) {
+	for ; index < ctx.candidates-1; index++ {
+		var bit byte
+		if bit, ok = ctx.bit(data, position); !ok {
+			return 0, 0, false
+		}
+		position++
+		if bit == 0 {
+			break
+		}
+	}
+	return index, position, true
+}
+
+// Returns the number of code bytes and mispredicted bytes of a block of the given
+// length in candidates mode, from its flags and the data that follows them.
+// Returns false if data ends before the codes.
+func (ctx *context) codeSize(flags byte, data []byte, length int) (size int, literals int, ok bool) {
+	var (
+		position uint
+		index    int
+	)
+
+	for i := 0; i < length; i++ {
+		if flags&(1<<uint(i)) != 0 {
+			continue
+		}
+		if index, position, ok = ctx.code(data, position); !ok {
+			return 0, 0, false
+		}
+		if index == 0 {
+			literals++
+		}
+	}
+	return ctx.codeBytes(position), literals, true
+}
+
+// Appends a code bit to dst, starting a new code byte if the current one is full
+func (ctx *context) putBit(dst []byte, bit byte) []byte {
+	if ctx.codes.used == 8 {
+		ctx.codes.at, ctx.codes.used = len(dst), 0
+		dst = append(dst, 0)
+	}
+	dst[ctx.codes.at] |= bit << ctx.codes.used
+	ctx.codes.used++
+	return dst
+}
+
+// Looks up a byte in the current slot and moves it to the front, or inserts it
+// at the front if it is missing. Returns its former index or -1 if it was missing.
+func (ctx *context) lookup(current byte) int {
+	var (
+		base int    = int(ctx.hash) * ctx.candidates
+		slot []byte = ctx.table[base : base+ctx.candidates]
+	)
+
+	for i, guess := range slot {
+		if guess == current {
+			copy(slot[1:i+1], slot[:i])
+			slot[0] = current
+			return i
+		}
+	}
+
+	ctx.counters.guess(slot[len(slot)-1], current)
+	copy(slot[1:], slot)
+	slot[0] = current
+	return -1
+}
+
+// Compresses a block of up to 8 bytes in candidates mode. The blocks of a group
+// must be appended to the same dst as their code bytes are shared.
+func (ctx *context) encodeCandidates(dst []byte, block []byte) []byte {
+	var (
+		header   int = len(dst)
+		flags    byte
+		literals [8]byte
+		count    int
+	)
+
+	dst = append(dst, 0)
+	for i, current := range block {
+		index := ctx.lookup(current)
+		switch {
+		case index == 0:
+			flags |= 1 << uint(i)
+		case index < 0:
+			literals[count] = current
+			count++
+			dst = ctx.putBit(dst, 0)
+		default:
+			// As many raised bits as the index, terminated unless it is the last one
+			for j := 0; j < index; j++ {
+				dst = ctx.putBit(dst, 1)
+			}
+			if index < ctx.candidates-1 {
+				dst = ctx.putBit(dst, 0)
+			}
+		}
+		ctx.update(current)
+	}
+	dst[header] = flags
+	ctx.counters.block(len(block)-count, len(block))
+	ctx.codes.block()
+
+	return append(dst, literals[:count]...)
+}
+
+// Decompresses a block of the given length in candidates mode
+// from its flags and the data that follows them, appending the result to dst
+func (ctx *context) decodeCandidates(dst []byte, flags byte, data []byte, length int) []byte {
+	var (
+		size, _, _ = ctx.codeSize(flags, data, length)
+		literals   = data[size:]
+		position   uint
+	)
+
+	for i := 0; i < length; i++ {
+		var (
+			index   int
+			current byte
+		)
+
+		if flags&(1<<uint(i)) == 0 {
+			index, position, _ = ctx.code(data, position)
+		}
+		if flags&(1<<uint(i)) == 0 && index == 0 {
+			// Guess failed, take the next read byte
+			current, literals = literals[0], literals[1:]
+		} else {
+			// Guess succeeded, fill in from the slot
+			current = ctx.table[int(ctx.hash)*ctx.candidates+index]
+		}
+
+		ctx.lookup(current)
+		dst = append(dst, current)
+		ctx.update(current)
+	}
+
+	// Continue with the last code byte of the block
+	if size > 0 {
+		ctx.codes.value, ctx.codes.used = data[size-1], position-(8-ctx.codes.used)-8*uint(size-1)
+	} else {
+		ctx.codes.used += position
+	}
+	ctx.codes.block()
+
+	return dst
+}
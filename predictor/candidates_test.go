@@ -0,0 +1,189 @@
+package predictor // import "github.com/spaskalev/misc/predictor"
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"testing"
+	"testing/iotest"
+)
+
+func TestCandidatesCycle(t *testing.T) {
+	for _, candidates := range []int{2, 4} {
+		opts := Options{TableBits: 12, Candidates: candidates}
+
+		for _, data := range [][]byte{input, textCorpus(1 << 14), binaryCorpus(1 << 14), randomCorpus(1 << 12)} {
+			// Every length exercises a different partial last block
+			for _, length := range []int{1, 2, 7, 9, 15, 16, 17, len(data)} {
+				compressor, _ := NewContext(opts)
+				decompressor, _ := NewContext(opts)
+
+				compressed := compressor.AppendCompress(nil, data[:length])
+				if result, err := decompressor.AppendDecompress(nil, compressed); err != nil || !bytes.Equal(result, data[:length]) {
+					t.Error("Unexpected result for", candidates, "candidates and length", length, err)
+				}
+			}
+		}
+
+		// The bare format is read a block at a time
+		var buf bytes.Buffer
+		w, _ := NewWriterOptions(&buf, opts)
+		w.Write(input)
+		w.Close()
+
+		r, _ := NewReaderOptions(iotest.OneByteReader(&buf), opts)
+		if err := checkReader(r, input); err != nil {
+			t.Error("Unexpected error for", candidates, "candidates", err)
+		}
+	}
+}
+
+func TestCandidatesFlush(t *testing.T) {
+	var data []byte = textCorpus(1 << 10)
+
+	for _, candidates := range []int{2, 4} {
+		opts := Options{Candidates: candidates}
+
+		// A partial group ends the bare stream
+		var buf bytes.Buffer
+		w, _ := NewWriterOptions(&buf, opts)
+		if _, err := w.Write(data[:100]); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Flush(); err != nil {
+			t.Fatal(err)
+		}
+		if count, err := w.Write(data[100:]); count != 0 || err != ErrFlushed {
+			t.Error("Unexpected write after a partial flush for", candidates, "candidates", count, err)
+		}
+		if err := w.Close(); err != nil {
+			t.Error("Unexpected error from Close", err)
+		}
+		r, _ := NewReaderOptions(&buf, opts)
+		if result, err := ioutil.ReadAll(r); err != nil || !bytes.Equal(result, data[:100]) {
+			t.Error("Unexpected result for", candidates, "candidates", len(result), err)
+		}
+
+		// The framed format continues in a new frame
+		buf.Reset()
+		fw, _ := NewFrameWriterOptions(&buf, opts)
+		for _, part := range [][]byte{data[:100], data[100:]} {
+			if _, err := fw.Write(part); err != nil {
+				t.Fatal(err)
+			}
+			if err := fw.Flush(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := fw.Close(); err != nil {
+			t.Fatal(err)
+		}
+		fr, _ := NewFrameReader(&buf)
+		if result, err := ioutil.ReadAll(fr); err != nil || !bytes.Equal(result, data) {
+			t.Error("Unexpected framed result for", candidates, "candidates", len(result), err)
+		}
+	}
+}
+
+func TestCandidatesTruncated(t *testing.T) {
+	opts := Options{TableBits: 12, Candidates: 4}
+	compressor, _ := NewContext(opts)
+	compressed := compressor.AppendCompress(nil, textCorpus(1<<10))
+
+	// A prefix that ends within the code bytes of a block can pass for a shorter
+	// last block, as in the bare RFC1978 format, but must not decompress to more data
+	for i := range compressed {
+		decompressor, _ := NewContext(opts)
+		if result, err := decompressor.AppendDecompress(nil, compressed[:i]); err == nil && len(result) >= 1<<10 {
+			t.Error("Unexpected result for prefix", i)
+		}
+	}
+}
+
+func TestCandidatesCorrupt(t *testing.T) {
+	var data []byte = randomCorpus(1 << 12)
+
+	// Any data decompresses without panicking
+	for _, candidates := range []int{2, 4} {
+		for i := 0; i < len(data); i += 97 {
+			decompressor, _ := NewContext(Options{Candidates: candidates})
+			decompressor.AppendDecompress(nil, data[:i])
+
+			r, _ := NewReaderOptions(bytes.NewReader(data[i:]), Options{Candidates: candidates})
+			ioutil.ReadAll(r)
+		}
+	}
+}
+
+func TestCandidatesFrame(t *testing.T) {
+	var data []byte = textCorpus(1 << 16)
+
+	dict, _ := TrainDictionary(Options{TableBits: 14, Candidates: 2}, data[:1<<10])
+	for _, opts := range []Options{{Candidates: 4}, {Candidates: 2, ChunkSize: 1 << 12}, dict.Options()} {
+		framed, err := chunked(data, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// The number of candidates is taken from the header
+		r, err := NewFrameReaderOptions(bytes.NewReader(framed), Options{Dictionary: opts.Dictionary})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result, err := ioutil.ReadAll(r); err != nil || !bytes.Equal(result, data) {
+			t.Error("Unexpected result for", opts, err)
+		}
+
+		at := headerSize
+		if opts.Dictionary != nil {
+			at += dictionaryIDSize
+		}
+		for _, candidates := range []byte{0, 1, 3, 8} {
+			invalid := append([]byte{}, framed...)
+			invalid[at] = candidates
+			if _, err := NewFrameReaderOptions(bytes.NewReader(invalid), Options{Dictionary: opts.Dictionary}); err != ErrHeader {
+				t.Error("Unexpected error for", candidates, "candidates", err)
+			}
+		}
+	}
+
+	// Dictionaries keep their candidates when serialized
+	var result Dictionary
+	serialized, _ := dict.MarshalBinary()
+	if err := result.UnmarshalBinary(serialized); err != nil || result.ID() != dict.ID() || result.Options().Candidates != 2 {
+		t.Error("Unexpected dictionary", result.Options(), err)
+	}
+	if _, err := NewWriterOptions(ioutil.Discard, Options{TableBits: 14, Dictionary: dict}); err != ErrOptions {
+		t.Error("Unexpected error for a dictionary with different candidates", err)
+	}
+}
+
+// Compares the compression ratio and speed for the number of candidates
+func BenchmarkCandidates(b *testing.B) {
+	var corpora = []struct {
+		name string
+		data []byte
+	}{
+		{"text", textCorpus(1 << 20)},
+		{"binary", binaryCorpus(1 << 20)},
+	}
+
+	for _, corpus := range corpora {
+		for _, candidates := range []int{1, 2, 4} {
+			opts := Options{Candidates: candidates}
+			b.Run(fmt.Sprintf("%s/candidates=%d", corpus.name, candidates), func(b *testing.B) {
+				var buf bytes.Buffer
+				w, _ := NewWriterOptions(&buf, opts)
+
+				b.SetBytes(int64(len(corpus.data)))
+				for i := 0; i < b.N; i++ {
+					buf.Reset()
+					w.Reset(&buf)
+					w.Write(corpus.data)
+					w.Close()
+				}
+				b.ReportMetric(float64(buf.Len())/float64(len(corpus.data)), "ratio")
+			})
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package predictor // import "github.com/spaskalev/misc/predictor"
+
+// A chunk is a frame that is compressed or decompressed independently
+// of the others, which allows for processing chunks concurrently.
+type chunk struct {
+	context
+	plain  []byte
+	packed []byte
+
+	// Whether the plain data is stored as is as it does not compress
+	stored bool
+
+	// The expected plain length, the frame's offset in the stream and
+	// the decompression result, for chunks that are being decompressed
+	length uint64
+	offset int64
+	err    error
+
+	// Closed when the chunk is processed
+	done chan struct{}
+}
+
+// Compresses the chunk's plain data with an empty guess table
+// and marks it as stored if it does not compress
+func (c *chunk) compress() {
+	c.reset()
+	c.packed = c.context.compress(reserve(c.packed, packedSize(len(c.plain))), c.plain)
+	c.stored = len(c.packed) >= len(c.plain)
+}
+
+// Decompresses the chunk's compressed data with an empty guess table.
+// The buffers are swapped for stored chunks.
+func (c *chunk) decompress() {
+	if c.stored {
+		c.plain, c.packed, c.err = c.packed, c.plain[:0], nil
+		return
+	}
+
+	c.reset()
+	c.plain, c.err = c.context.decompress(reserve(c.plain, int(c.length)), c.packed)
+}
+
+// Returns the guess table and the buffers of the chunk to the buffer pool
+func (c *chunk) release() {
+	c.context.release()
+	putBuffer(&c.plain)
+	putBuffer(&c.packed)
+}
+
+// Processes up to a number of chunks concurrently while keeping their order
+// and reuses the processed ones.
+type chunks struct {
+	opts    Options
+	free    []*chunk
+	pending []*chunk
+}
+
+// Sets the options for new chunks, dropping the free ones if the options change
+func (cs *chunks) init(opts Options) {
+	if opts != cs.opts {
+		cs.release()
+		cs.free = cs.free[:0]
+	}
+	cs.opts = opts
+}
+
+// Returns the guess tables and the buffers of the free chunks
+// to the buffer pool until they are reset for reuse
+func (cs *chunks) release() {
+	for _, c := range cs.free {
+		c.release()
+	}
+}
+
+// Returns a free chunk
+func (cs *chunks) get() *chunk {
+	if count := len(cs.free); count > 0 {
+		c := cs.free[count-1]
+		cs.free = cs.free[:count-1]
+		return c
+	}
+
+	var c chunk
+	c.init(cs.opts)
+	return &c
+}
+
+// Returns a processed chunk for reuse
+func (cs *chunks) put(c *chunk) {
+	cs.free = append(cs.free, c)
+}
+
+// Starts processing a chunk on a separate goroutine
+func (cs *chunks) start(c *chunk, work func(*chunk)) {
+	c.done = make(chan struct{})
+	cs.pending = append(cs.pending, c)
+	go func() {
+		work(c)
+		close(c.done)
+	}()
+}
+
+// Returns whether the number of chunks in flight reached the number of workers
+func (cs *chunks) full() bool {
+	return len(cs.pending) >= cs.opts.workers()
+}
+
+// Waits for the oldest chunk in flight and returns it
+func (cs *chunks) next() *chunk {
+	c := cs.pending[0]
+	<-c.done
+	cs.pending = cs.pending[:copy(cs.pending, cs.pending[1:])]
+	return c
+}
+
+// Waits for every chunk in flight and frees it
+func (cs *chunks) drain() {
+	for len(cs.pending) > 0 {
+		cs.put(cs.next())
+	}
+}
@@ -0,0 +1,205 @@
+package predictor // import "github.com/spaskalev/misc/predictor"
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"testing"
+	"testing/iotest"
+)
+
+// Compresses the input in chunked mode with the given options
+func chunked(input []byte, opts Options) ([]byte, error) {
+	var buf bytes.Buffer
+
+	w, err := NewFrameWriterOptions(&buf, opts)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = w.Write(input); err != nil {
+		return nil, err
+	}
+
+	err = w.Close()
+	return buf.Bytes(), err
+}
+
+func TestChunkCycle(t *testing.T) {
+	var cases = []struct {
+		data      []byte
+		chunkSize int
+	}{
+		{input, 1},
+		{input, 7},
+		{input, 8},
+		{textCorpus(1<<16 + 3), 1000},
+		{textCorpus(1<<16 + 3), frameSize},
+	}
+
+	for _, c := range cases {
+		var (
+			data      []byte = c.data
+			chunkSize int    = c.chunkSize
+			expected  []byte
+		)
+
+		for _, workers := range []int{1, 2, 8} {
+			framed, err := chunked(data, Options{ChunkSize: chunkSize, Workers: workers})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// The output does not depend on the number of workers
+			if expected == nil {
+				expected = framed
+			} else if !bytes.Equal(framed, expected) {
+				t.Error("Unexpected output for chunk size", chunkSize, "workers", workers)
+			}
+
+			r, err := NewFrameReaderOptions(iotest.HalfReader(bytes.NewReader(framed)), Options{Workers: workers})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result, err := ioutil.ReadAll(r); err != nil || !bytes.Equal(result, data) {
+				t.Error("Unexpected result for chunk size", chunkSize, "workers", workers, err)
+			}
+		}
+	}
+}
+
+func TestChunkIndependent(t *testing.T) {
+	framed, err := chunked(append(append([]byte{}, input...), input...), Options{ChunkSize: len(input)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Both chunks compress to the sample output as each starts with an empty table
+	if bytes.Count(framed, output) != 2 {
+		t.Errorf("Unexpected chunked output %#x", framed)
+	}
+}
+
+func TestChunkFlush(t *testing.T) {
+	var (
+		buf  bytes.Buffer
+		data []byte = textCorpus(1 << 12)
+	)
+
+	w, err := NewFrameWriterOptions(&buf, Options{ChunkSize: 1 << 10, Workers: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < len(data); i += 100 {
+		end := i + 100
+		if end > len(data) {
+			end = len(data)
+		}
+
+		w.Write(data[i:end])
+		if err := w.Flush(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	w.Close()
+
+	r, err := NewFrameReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result, err := ioutil.ReadAll(r); err != nil || !bytes.Equal(result, data) {
+		t.Error("Unexpected result", err)
+	}
+}
+
+func TestChunkCorrupt(t *testing.T) {
+	var data []byte = append(append(append([]byte{}, input...), input...), input...)
+
+	framed, err := chunked(data, Options{ChunkSize: len(input)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Modify the plain length of the second chunk
+	var second int = headerSize + 2 + len(output)
+	framed[second]--
+
+	r, err := NewFrameReaderOptions(bytes.NewReader(framed), Options{Workers: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := ioutil.ReadAll(r)
+	if err != CorruptInputError(second+2) {
+		t.Error("Unexpected error", err)
+	}
+	if !bytes.Equal(result, input) {
+		t.Error("Unexpected result", result)
+	}
+
+	// The reader is usable after a reset
+	framed[second]++
+	if err := r.Reset(bytes.NewReader(framed)); err != nil {
+		t.Fatal(err)
+	}
+	if result, err := ioutil.ReadAll(r); err != nil || !bytes.Equal(result, data) {
+		t.Error("Unexpected result after reset", err)
+	}
+}
+
+func TestChunkResetOptions(t *testing.T) {
+	var data []byte = textCorpus(1 << 12)
+
+	first, err := chunked(data, Options{ChunkSize: 1 << 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := chunked(data, Options{ChunkSize: 1 << 10, TableBits: 20, Candidates: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The free chunks are not reused across streams with other options
+	r, err := NewFrameReader(bytes.NewReader(first))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, framed := range [][]byte{first, second, first} {
+		if err := r.Reset(bytes.NewReader(framed)); err != nil {
+			t.Fatal(err)
+		}
+		if result, err := ioutil.ReadAll(r); err != nil || !bytes.Equal(result, data) {
+			t.Error("Unexpected result after reset", err)
+		}
+	}
+}
+
+// Measures the compression ratio loss and the speed for various chunk sizes
+func BenchmarkChunkSize(b *testing.B) {
+	var corpora = []struct {
+		name string
+		data []byte
+	}{
+		{"text", textCorpus(1 << 22)},
+		{"binary", binaryCorpus(1 << 22)},
+	}
+
+	for _, corpus := range corpora {
+		for _, chunkSize := range []int{0, 1 << 12, 1 << 14, 1 << 16, 1 << 18, 1 << 20} {
+			opts := Options{ChunkSize: chunkSize}
+			b.Run(fmt.Sprintf("%s/chunk=%d", corpus.name, chunkSize), func(b *testing.B) {
+				var buf bytes.Buffer
+				w, _ := NewFrameWriterOptions(&buf, opts)
+
+				b.SetBytes(int64(len(corpus.data)))
+				for i := 0; i < b.N; i++ {
+					buf.Reset()
+					w.Reset(&buf)
+					w.Write(corpus.data)
+					w.Close()
+				}
+				b.ReportMetric(float64(buf.Len())/float64(len(corpus.data)), "ratio")
+			})
+		}
+	}
+}
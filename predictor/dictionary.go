@@ -0,0 +1,116 @@
+package predictor // import "github.com/spaskalev/misc/predictor"
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+)
+
+// The serialized dictionary format
+//
+//	magic (4 bytes), table bits (1 byte), hash (1 byte), guess table
+var dictionaryMagic = [4]byte{'P', 'D', 'D', 0x1a}
+
+// The size of a serialized dictionary before its guess table
+const dictionaryHeaderSize = len(dictionaryMagic) + 2
+
+// ErrDictionary is returned when reading a framed stream that was compressed with
+// a different dictionary or none was provided, and for invalid serialized dictionaries.
+var ErrDictionary = errors.New("predictor: invalid dictionary")
+
+// A Dictionary is a snapshot of a guess table that was trained from sample data.
+// Seeding the guess table with a dictionary that resembles the data allows for
+// compressing short messages from their first byte. The same dictionary must be
+// used for decompressing the data.
+//
+// A Dictionary is immutable and safe for concurrent use.
+type Dictionary struct {
+	// The table size, hash function and candidates, with the defaults applied
+	opts     Options
+	table    []byte
+	occupied int
+	id       uint32
+}
+
+// Returns a new Dictionary for the given table size, hash function and candidates
+// that is trained on the provided samples. Each sample is treated
+// as the start of a message and later samples take precedence.
+func TrainDictionary(opts Options, samples ...[]byte) (*Dictionary, error) {
+	opts = Options{TableBits: opts.tableBits(), Hash: opts.Hash, Candidates: opts.candidates()}
+	if err := opts.check(); err != nil {
+		return nil, err
+	}
+
+	var ctx context
+	ctx.init(opts)
+	for _, sample := range samples {
+		ctx.hash = 0
+		ctx.train(sample)
+	}
+
+	return newDictionary(opts, ctx.table), nil
+}
+
+// Returns a new Dictionary over the given guess table
+func newDictionary(opts Options, table []byte) *Dictionary {
+	var d Dictionary = Dictionary{opts: opts, table: table}
+	for _, guess := range table {
+		if guess != 0 {
+			d.occupied++
+		}
+	}
+
+	data, _ := d.MarshalBinary()
+	d.id = crc32.ChecksumIEEE(data)
+	return &d
+}
+
+// Returns the dictionary's identifier, which is stored in the header of framed streams
+func (d *Dictionary) ID() uint32 {
+	return d.id
+}
+
+// Returns the table size, hash function and candidates that the dictionary
+// was trained with. They must be used along with the dictionary.
+func (d *Dictionary) Options() Options {
+	var opts Options = d.opts
+	opts.Dictionary = d
+	return opts
+}
+
+// Implements encoding.BinaryMarshaler
+//
+// The number of candidates is implied by the size of the guess table.
+func (d *Dictionary) MarshalBinary() ([]byte, error) {
+	data := make([]byte, 0, dictionaryHeaderSize+len(d.table))
+	data = append(data, dictionaryMagic[:]...)
+	data = append(data, byte(d.opts.TableBits), byte(d.opts.Hash))
+	return append(data, d.table...), nil
+}
+
+// Implements encoding.BinaryUnmarshaler
+//
+// Returns ErrDictionary if the data is not a valid serialized dictionary.
+func (d *Dictionary) UnmarshalBinary(data []byte) error {
+	if len(data) < dictionaryHeaderSize || binary.LittleEndian.Uint32(data) != binary.LittleEndian.Uint32(dictionaryMagic[:]) {
+		return ErrDictionary
+	}
+
+	opts := Options{TableBits: uint(data[4]), Hash: Hash(data[5])}
+	if opts.TableBits == 0 || opts.TableBits > maxTableBits {
+		return ErrDictionary
+	}
+	opts.Candidates = (len(data) - dictionaryHeaderSize) >> opts.TableBits
+	if opts.check() != nil || opts.tableSize() != len(data)-dictionaryHeaderSize {
+		return ErrDictionary
+	}
+
+	table := append([]byte{}, data[dictionaryHeaderSize:]...)
+	*d = *newDictionary(opts, table)
+	return nil
+}
+
+// Returns whether the dictionary can be used with the given table size, hash function and candidates
+func (d *Dictionary) matches(opts Options) bool {
+	return d.opts.TableBits == opts.tableBits() && d.opts.Hash == opts.Hash && d.opts.Candidates == opts.candidates()
+}
@@ -0,0 +1,160 @@
+package predictor // import "github.com/spaskalev/misc/predictor"
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"testing"
+)
+
+// Returns short JSON messages sharing the same keys
+func messages(count int) (result [][]byte) {
+	for i := 0; i < count; i++ {
+		result = append(result, []byte(fmt.Sprintf(`{"id":%d,"name":"user%d","active":%t,"roles":["reader"]}`, i, i*7, i%3 == 0)))
+	}
+	return result
+}
+
+func TestDictionaryCompress(t *testing.T) {
+	var samples [][]byte = messages(100)
+
+	dict, err := TrainDictionary(Options{TableBits: 14, Hash: Order3}, samples[:50]...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	compressor, _ := NewContext(dict.Options())
+	decompressor, _ := NewContext(dict.Options())
+	plain, _ := NewContext(Options{TableBits: 14, Hash: Order3})
+
+	for _, message := range samples[50:] {
+		compressor.Reset()
+		plain.Reset()
+
+		seeded := compressor.AppendCompress(nil, message)
+		if unseeded := plain.AppendCompress(nil, message); len(seeded)*2 > len(unseeded) {
+			t.Errorf("Unexpected compressed size %d, %d without a dictionary", len(seeded), len(unseeded))
+		}
+
+		decompressor.Reset()
+		if result, err := decompressor.AppendDecompress(nil, seeded); err != nil || !bytes.Equal(result, message) {
+			t.Error("Unexpected result", result, err)
+		}
+	}
+}
+
+func TestDictionaryStream(t *testing.T) {
+	var (
+		buf     bytes.Buffer
+		message []byte = messages(1)[0]
+	)
+
+	dict, _ := TrainDictionary(Options{}, messages(10)...)
+	w, err := NewWriterOptions(&buf, dict.Options())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A reset writer seeds its guess table again
+	w.Write(message)
+	w.Reset(&buf)
+	w.Write(message)
+	w.Close()
+
+	r, _ := NewReaderOptions(bytes.NewReader(buf.Bytes()[:buf.Len()/2]), dict.Options())
+	if result, err := ioutil.ReadAll(r); err != nil || !bytes.Equal(result, message) {
+		t.Error("Unexpected result", result, err)
+	}
+
+	r.Reset(bytes.NewReader(buf.Bytes()[buf.Len()/2:]))
+	if result, err := ioutil.ReadAll(r); err != nil || !bytes.Equal(result, message) {
+		t.Error("Unexpected result after reset", result, err)
+	}
+}
+
+func TestDictionaryFrame(t *testing.T) {
+	var (
+		data  []byte = bytes.Join(messages(100), []byte("\n"))
+		dict  *Dictionary
+		other *Dictionary
+	)
+
+	dict, _ = TrainDictionary(Options{TableBits: 12}, messages(10)...)
+	other, _ = TrainDictionary(Options{TableBits: 12}, input)
+
+	for _, opts := range []Options{{}, {ChunkSize: 100, Seekable: true}} {
+		opts.TableBits, opts.Dictionary = 12, dict
+
+		framed, err := chunked(data, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, d := range []*Dictionary{nil, other} {
+			if _, err := NewFrameReaderOptions(bytes.NewReader(framed), Options{Dictionary: d}); err != ErrDictionary {
+				t.Error("Unexpected error for a missing or different dictionary", err)
+			}
+		}
+
+		r, err := NewFrameReaderOptions(bytes.NewReader(framed), Options{Dictionary: dict})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result, err := ioutil.ReadAll(r); err != nil || !bytes.Equal(result, data) {
+			t.Error("Unexpected result for", opts, err)
+		}
+
+		if opts.Seekable {
+			s, err := NewSeekableReaderOptions(bytes.NewReader(framed), int64(len(framed)), Options{Dictionary: dict})
+			if err != nil {
+				t.Fatal(err)
+			}
+			result := make([]byte, 300)
+			if _, err := s.ReadAt(result, 1000); err != nil || !bytes.Equal(result, data[1000:1300]) {
+				t.Error("Unexpected seekable result", err)
+			}
+		}
+	}
+
+	// A stream without a dictionary ignores the provided one
+	framed, _ := frame(data, len(data))
+	if _, err := NewFrameReaderOptions(bytes.NewReader(framed), Options{Dictionary: dict}); err != nil {
+		t.Error("Unexpected error", err)
+	}
+}
+
+func TestDictionaryMarshal(t *testing.T) {
+	dict, _ := TrainDictionary(Options{TableBits: 12, Hash: Order2}, messages(10)...)
+
+	data, err := dict.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var result Dictionary
+	if err := result.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+	if result.ID() != dict.ID() || result.Options().TableBits != 12 || result.Options().Hash != Order2 || result.occupied != dict.occupied {
+		t.Error("Unexpected dictionary", result.Options())
+	}
+
+	// Dictionaries of different data have different identifiers
+	if other, _ := TrainDictionary(Options{TableBits: 12, Hash: Order2}, input); other.ID() == dict.ID() {
+		t.Error("Unexpected identifier", other.ID())
+	}
+
+	for _, invalid := range [][]byte{nil, data[:len(data)-1], append([]byte{0}, data[1:]...), append(data[:4:4], 11, 0)} {
+		if err := result.UnmarshalBinary(invalid); err != ErrDictionary {
+			t.Error("Unexpected error", err)
+		}
+	}
+
+	// The dictionary must match the table size and hash function
+	if _, err := NewWriterOptions(ioutil.Discard, Options{TableBits: 12, Dictionary: dict}); err != ErrOptions {
+		t.Error("Unexpected error", err)
+	}
+	if _, err := TrainDictionary(Options{TableBits: 30}); err != ErrOptions {
+		t.Error("Unexpected error", err)
+	}
+}
@@ -0,0 +1,764 @@
+package predictor // import "github.com/spaskalev/misc/predictor"
+
+// The framed format wraps predictor-compressed data in a self-describing container
+//
+//	header:  magic (4 bytes), version (1 byte), flags (1 byte),
+//	         table bits (1 byte), hash (1 byte),
+//	         dictionary ID (4 bytes, little endian, if flagged),
+//	         candidates (1 byte, if flagged)
+//	frames:  plain length (uvarint), compressed length (uvarint), compressed blocks
+//	         or a compressed length of zero followed by the plain data, if stored
+//	end:     a plain length of zero
+//	trailer: total plain length (8 bytes, little endian),
+//	         CRC-32 (IEEE) of the plain data (4 bytes, little endian)
+//	index:   plain length and framed length of each chunk (uvarints),
+//	         index length (4 bytes, little endian), magic (4 bytes)
+//
+// The guess table is shared across the frames of a stream. Each frame
+// carries its own length so that it can end with a partial block.
+// Frames that do not compress are stored as is, which caps the expansion
+// of incompressible data. The guess table is still updated with their data.
+// In chunked mode every frame starts with an empty guess table instead,
+// which allows for compressing and decompressing frames concurrently.
+// Chunked streams can also be seekable, in which case the trailer is
+// followed by an index of the chunks for locating them from the end.
+// The guess table, including that of every chunk, can be seeded from
+// a dictionary, which is identified in the header.
+// The guess table keeps several candidate guesses per slot in candidates mode.
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	iou "github.com/spaskalev/misc/ioutil"
+	"hash"
+	"hash/crc32"
+	"io"
+)
+
+// Identifies the framed format
+var magic = [4]byte{'P', 'D', 'C', 0x1a}
+
+const (
+	// The version of the framed format
+	version = 1
+
+	// The amount of plain data that is buffered before writing a frame
+	frameSize = 1 << 16
+
+	// The maximum amount of plain data in a frame that a FrameReader accepts
+	maxFrameSize = 1 << 24
+
+	// The amount of consecutive incompressible data that is buffered before writing a stored frame
+	storedSize = 1 << 20
+
+	// The sizes of the header, without a dictionary ID, and the trailer
+	headerSize  = len(magic) + 4
+	trailerSize = 8 + 4
+
+	// The size of the optional header fields
+	dictionaryIDSize = 4
+	candidatesSize   = 1
+)
+
+var (
+	// ErrHeader is returned when reading data that does not start with a valid header
+	ErrHeader = errors.New("predictor: invalid header")
+
+	// ErrVersion is returned when reading a framed stream of an unsupported version
+	ErrVersion = errors.New("predictor: unsupported version")
+
+	// ErrChecksum is returned when the checksum of the plain data does not match the trailer
+	ErrChecksum = errors.New("predictor: invalid checksum")
+
+	// ErrLength is returned when the length of the plain data does not match the trailer
+	ErrLength = errors.New("predictor: invalid length")
+)
+
+// A CorruptInputError reports the offset in the framed stream
+// after which the compressed data is not valid.
+type CorruptInputError int64
+
+func (e CorruptInputError) Error() string {
+	return fmt.Sprintf("predictor: corrupt input after offset %d", int64(e))
+}
+
+// The parameters of a framed stream, as stored in its header
+type header struct {
+	// Optional features
+	flags byte
+	opts  Options
+}
+
+// The header flags
+const (
+	// Each frame is compressed with a fresh context
+	flagChunked = 1 << iota
+
+	// An index of the chunks follows the trailer
+	flagSeekable
+
+	// The guess table is seeded from a dictionary
+	flagDictionary
+
+	// The guess table keeps several guesses per slot
+	flagCandidates
+
+	// All known flags
+	knownFlags = flagChunked | flagSeekable | flagDictionary | flagCandidates
+)
+
+// Returns the size of the encoded header
+func (h header) size() int {
+	var size int = headerSize
+	if h.flags&flagDictionary != 0 {
+		size += dictionaryIDSize
+	}
+	if h.flags&flagCandidates != 0 {
+		size += candidatesSize
+	}
+	return size
+}
+
+// Appends the encoded header to dst
+func (h header) append(dst []byte) []byte {
+	dst = append(dst, magic[:]...)
+	dst = append(dst, version, h.flags, byte(h.opts.tableBits()), byte(h.opts.Hash))
+	if h.flags&flagDictionary != 0 {
+		var id [dictionaryIDSize]byte
+		binary.LittleEndian.PutUint32(id[:], h.opts.Dictionary.ID())
+		dst = append(dst, id[:]...)
+	}
+	if h.flags&flagCandidates != 0 {
+		dst = append(dst, byte(h.opts.Candidates))
+	}
+	return dst
+}
+
+// Reads and verifies a header. Streams that are compressed with a dictionary
+// can only be read with the same dictionary, which is set in the header's options.
+func readHeader(reader io.Reader, dict *Dictionary) (header, error) {
+	var (
+		h   header
+		buf [headerSize + dictionaryIDSize + candidatesSize]byte
+	)
+
+	if _, err := io.ReadFull(reader, buf[:len(magic)+1]); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			err = ErrHeader
+		}
+		return h, err
+	}
+
+	if [len(magic)]byte{buf[0], buf[1], buf[2], buf[3]} != magic {
+		return h, ErrHeader
+	}
+
+	if buf[len(magic)] != version {
+		return h, ErrVersion
+	}
+
+	if _, err := io.ReadFull(reader, buf[len(magic)+1:headerSize]); err != nil {
+		return h, unexpected(err)
+	}
+
+	h.flags, h.opts.TableBits, h.opts.Hash = buf[5], uint(buf[6]), Hash(buf[7])
+	if h.flags&^knownFlags != 0 || (h.flags&flagSeekable != 0 && h.flags&flagChunked == 0) || h.opts.check() != nil {
+		return h, ErrHeader
+	}
+
+	if _, err := io.ReadFull(reader, buf[headerSize:h.size()]); err != nil {
+		return h, unexpected(err)
+	}
+	if h.flags&flagCandidates != 0 {
+		if h.opts.Candidates = int(buf[h.size()-candidatesSize]); h.opts.Candidates < 2 || h.opts.check() != nil {
+			return h, ErrHeader
+		}
+	}
+	if h.flags&flagDictionary != 0 {
+		if dict == nil || dict.ID() != binary.LittleEndian.Uint32(buf[headerSize:]) || !dict.matches(h.opts) {
+			return h, ErrDictionary
+		}
+		h.opts.Dictionary = dict
+	}
+	return h, nil
+}
+
+// Compresses src by appending its compressed blocks to dst.
+// The last block is partial if the length of src is not a multiple of 8.
+func (ctx *context) compress(dst []byte, src []byte) []byte {
+	ctx.codes.reset()
+	return ctx.encodeBlocks(dst, src)
+}
+
+// Compresses src in blocks, continuing the current group of blocks
+func (ctx *context) encodeBlocks(dst []byte, src []byte) []byte {
+	for len(src) > 8 {
+		dst, src = ctx.encode(dst, src[:8]), src[8:]
+	}
+	if len(src) > 0 {
+		dst = ctx.encode(dst, src)
+	}
+	return dst
+}
+
+// Decompresses src by appending the plain data to dst.
+// Returns io.ErrUnexpectedEOF if the last block of src is truncated.
+func (ctx *context) decompress(dst []byte, src []byte) ([]byte, error) {
+	ctx.codes.reset()
+	for len(src) > 0 {
+		var (
+			flags  byte   = src[0]
+			data   []byte = src[1:]
+			length int    = 8
+		)
+
+		if size, ok := ctx.blockSize(flags, data, length); ok && size <= len(data) {
+			// A full block
+			data, src = data[:size], data[size:]
+		} else {
+			// The last block, which is partial and can not have predicted bytes beyond its length
+			if length, src = ctx.blockLength(flags, data), nil; length == 0 {
+				return dst, io.ErrUnexpectedEOF
+			}
+		}
+		dst = ctx.decode(dst, flags, data, length)
+	}
+	return dst, nil
+}
+
+// A FrameWriter compresses data according to the predictor algorithm
+// and writes it in the framed format to an underlying io.Writer.
+//
+// Data is buffered and written in frames. Close must be called
+// to write the end of the stream and its trailer.
+type FrameWriter struct {
+	context
+	header   header
+	target   io.Writer
+	err      error
+	started  bool
+	length   uint64
+	out      uint64
+	checksum hash.Hash32
+
+	// Plain data of the current frame and scratch space for its compressed form
+	frame  []byte
+	output []byte
+
+	// Plain data of consecutive incompressible frames that are written as a stored frame
+	stored []byte
+
+	// Frames that are compressed concurrently in chunked mode
+	chunks chunks
+
+	// The plain and the framed size of every chunk, in seekable mode
+	index []byte
+}
+
+// Returns a new FrameWriter that writes the framed format to the provided io.Writer
+func NewFrameWriter(writer io.Writer) *FrameWriter {
+	w, _ := NewFrameWriterOptions(writer, Options{})
+	return w
+}
+
+// Returns a new FrameWriter with the given options that writes the framed format
+// to the provided io.Writer. The options are recorded in the stream's header.
+func NewFrameWriterOptions(writer io.Writer, opts Options) (*FrameWriter, error) {
+	if err := opts.check(); err != nil {
+		return nil, err
+	}
+
+	var w FrameWriter
+	w.header.opts = opts
+	w.target = writer
+	w.checksum = crc32.NewIEEE()
+
+	if opts.Seekable {
+		w.header.flags |= flagSeekable
+	}
+	if opts.Dictionary != nil {
+		w.header.flags |= flagDictionary
+	}
+	if opts.candidates() > 1 {
+		w.header.flags |= flagCandidates
+	}
+	if opts.ChunkSize > 0 {
+		w.header.flags |= flagChunked
+		w.chunks.init(opts)
+	} else {
+		w.init(opts)
+	}
+	return &w, nil
+}
+
+// Implements io.Writer
+//
+// The first error of the underlying writer ends the stream
+// and is returned by every later call.
+func (w *FrameWriter) Write(data []byte) (int, error) {
+	var (
+		total int
+		limit int = w.frameLimit()
+	)
+
+	for w.err == nil && len(data) > 0 {
+		if len(w.frame) == 0 {
+			w.frame = reserve(w.frame, limit)
+		}
+
+		count := len(data)
+		if free := limit - len(w.frame); count > free {
+			count = free
+		}
+
+		w.frame = append(w.frame, data[:count]...)
+		data, total = data[count:], total+count
+
+		if len(w.frame) == limit {
+			w.err = w.writeFrame()
+		}
+	}
+
+	return total, w.err
+}
+
+// Returns the amount of plain data in a frame
+func (w *FrameWriter) frameLimit() int {
+	if w.header.opts.ChunkSize > 0 {
+		return w.header.opts.ChunkSize
+	}
+	return frameSize
+}
+
+// Writes the header if it is not written yet
+func (w *FrameWriter) writeHeader() error {
+	if w.started {
+		return nil
+	}
+	w.started = true
+
+	var buf [headerSize + dictionaryIDSize + candidatesSize]byte
+	return w.write(w.header.append(buf[:0]))
+}
+
+// Compresses and writes the current frame, if any
+func (w *FrameWriter) writeFrame() error {
+	if err := w.writeHeader(); err != nil || len(w.frame) == 0 {
+		return err
+	}
+
+	w.length += uint64(len(w.frame))
+	w.checksum.Write(w.frame)
+
+	if w.header.flags&flagChunked != 0 {
+		return w.writeChunk()
+	}
+
+	w.output = w.compress(reserve(w.output, packedSize(len(w.frame))), w.frame)
+	if len(w.output) >= len(w.frame) {
+		w.stored = appendBuffer(w.stored, w.frame)
+		w.frame = w.frame[:0]
+		if len(w.stored) < storedSize {
+			return nil
+		}
+		return w.writeStored()
+	}
+
+	err := w.writeStored()
+	if err == nil {
+		err = w.writePacked(len(w.frame), w.output, false)
+	}
+	w.frame = w.frame[:0]
+	return err
+}
+
+// Writes the buffered incompressible data as a stored frame, if any
+func (w *FrameWriter) writeStored() error {
+	if len(w.stored) == 0 {
+		return nil
+	}
+
+	err := w.writePacked(len(w.stored), w.stored, true)
+	w.stored = w.stored[:0]
+	return err
+}
+
+// Hands the current frame over for compression and writes
+// the oldest chunks while there are too many in flight
+func (w *FrameWriter) writeChunk() error {
+	c := w.chunks.get()
+	c.plain, w.frame = w.frame, c.plain[:0]
+	w.chunks.start(c, (*chunk).compress)
+
+	for w.chunks.full() {
+		if err := w.writeNext(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Waits for the oldest chunk in flight and writes it
+func (w *FrameWriter) writeNext() error {
+	c := w.chunks.next()
+	defer w.chunks.put(c)
+	w.counters.add(c.counters)
+	if c.stored {
+		return w.writePacked(len(c.plain), c.plain, true)
+	}
+	return w.writePacked(len(c.plain), c.packed, false)
+}
+
+// Writes a frame's lengths and compressed data, or its plain data if stored
+func (w *FrameWriter) writePacked(length int, packed []byte, stored bool) error {
+	var (
+		header [2 * binary.MaxVarintLen64]byte
+		size   int = binary.PutUvarint(header[:], uint64(length))
+	)
+
+	if stored {
+		size += binary.PutUvarint(header[size:], 0)
+	} else {
+		size += binary.PutUvarint(header[size:], uint64(len(packed)))
+	}
+	if err := w.write(header[:size]); err != nil {
+		return err
+	}
+
+	if w.header.flags&flagSeekable != 0 {
+		var entry [2 * binary.MaxVarintLen64]byte
+		count := binary.PutUvarint(entry[:], uint64(length))
+		count += binary.PutUvarint(entry[count:], uint64(size+len(packed)))
+		w.index = appendBuffer(w.index, entry[:count])
+	}
+	return w.write(packed)
+}
+
+// Writes to the underlying writer, treating short writes as errors
+func (w *FrameWriter) write(data []byte) error {
+	count, err := iou.WriteFull(w.target, data)
+	w.out += uint64(count)
+	return err
+}
+
+// Compresses and writes any buffered data as a frame,
+// waiting for every chunk in flight in chunked mode
+func (w *FrameWriter) Flush() error {
+	if w.err == nil {
+		w.err = w.writeFrame()
+	}
+	if w.err == nil {
+		w.err = w.writeStored()
+	}
+	for w.err == nil && len(w.chunks.pending) > 0 {
+		w.err = w.writeNext()
+	}
+	return w.err
+}
+
+// Flushes the FrameWriter and writes the end of the stream and its trailer,
+// followed by the chunk index in seekable mode.
+// It does not close the underlying writer.
+//
+// The guess tables and the buffers are returned to the buffer pool,
+// even if writing fails, until the FrameWriter is reset.
+func (w *FrameWriter) Close() error {
+	if w.err == ErrClosed {
+		return nil
+	}
+
+	defer w.release()
+
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	var trailer [1 + trailerSize]byte
+	binary.LittleEndian.PutUint64(trailer[1:], w.length)
+	binary.LittleEndian.PutUint32(trailer[9:], w.checksum.Sum32())
+	if w.err = w.write(trailer[:]); w.err != nil {
+		return w.err
+	}
+
+	if w.header.flags&flagSeekable != 0 {
+		if w.err = w.writeIndex(); w.err != nil {
+			return w.err
+		}
+	}
+
+	w.err = ErrClosed
+	return nil
+}
+
+// Returns the guess tables and the buffers to the buffer pool
+func (w *FrameWriter) release() {
+	w.context.release()
+	w.chunks.release()
+	putBuffer(&w.frame)
+	putBuffer(&w.output)
+	putBuffer(&w.stored)
+	putBuffer(&w.index)
+}
+
+// Discards the FrameWriter's state and makes it equivalent to
+// a new FrameWriter with the same options over the provided io.Writer.
+func (w *FrameWriter) Reset(writer io.Writer) {
+	w.context.reset()
+	w.chunks.drain()
+	w.checksum.Reset()
+	w.target, w.err, w.started, w.length, w.out = writer, nil, false, 0, 0
+	w.frame, w.stored, w.index = w.frame[:0], w.stored[:0], w.index[:0]
+}
+
+// A FrameReader decompresses data in the framed format from an underlying io.Reader.
+//
+// The header, the lengths and the checksum are verified and any mismatch
+// is reported as an error. A stream that ends before its trailer results
+// in io.ErrUnexpectedEOF. The guess tables and the buffers are returned to
+// the buffer pool once the stream ends or fails, until the FrameReader is reset.
+type FrameReader struct {
+	context
+	header   header
+	workers  int
+	dict     *Dictionary
+	source   sourceReader
+	err      error
+	length   uint64
+	checksum hash.Hash32
+	trailer  [trailerSize]byte
+
+	// Plain data of the current frame and scratch space for its compressed form
+	frame []byte
+	from  int
+	input []byte
+
+	// Frames that are decompressed concurrently in chunked mode,
+	// the one being returned and the error that stops reading more
+	chunks  chunks
+	current *chunk
+	ended   error
+}
+
+// Returns a new FrameReader that reads the framed format from the provided io.Reader.
+// The header is read and verified before returning and the options
+// that the stream was compressed with are taken from it.
+func NewFrameReader(reader io.Reader) (*FrameReader, error) {
+	return NewFrameReaderOptions(reader, Options{})
+}
+
+// Returns a new FrameReader like NewFrameReader that uses the given options
+// for decompressing. Only the options that are not recorded in the stream's
+// header are taken into account, which are the number of workers and the
+// dictionary. The dictionary is used only if the stream requires it.
+func NewFrameReaderOptions(reader io.Reader, opts Options) (*FrameReader, error) {
+	if err := opts.readerOptions().check(); err != nil {
+		return nil, err
+	}
+
+	var r FrameReader
+	r.workers, r.dict = opts.Workers, opts.Dictionary
+	r.checksum = crc32.NewIEEE()
+	if err := r.Reset(reader); err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// Implements io.Reader
+func (r *FrameReader) Read(output []byte) (int, error) {
+	var total int
+
+	for len(output) > 0 {
+		// Reply with the decompressed data if there is any
+		if r.from < len(r.frame) {
+			count := copy(output, r.frame[r.from:])
+			r.from += count
+			output, total = output[count:], total+count
+			continue
+		}
+
+		// Return what is available rather than wait for more
+		if r.err != nil || total > 0 {
+			break
+		}
+		if r.err = r.readFrame(); r.err != nil {
+			r.release()
+		}
+	}
+
+	if total > 0 {
+		return total, nil
+	}
+	return 0, r.err
+}
+
+// Reads and decompresses the next frame, or verifies the trailer at the end of the stream
+func (r *FrameReader) readFrame() error {
+	if r.header.flags&flagChunked != 0 {
+		return r.readChunk()
+	}
+
+	length, packed, stored, offset, err := r.readPacked(r.input[:0])
+	r.input = packed
+	if err != nil {
+		return err
+	}
+	if length == 0 {
+		return r.checkTrailer()
+	}
+
+	if stored {
+		// Swap the buffers and update the guess table like the compressor did
+		r.frame, r.input = packed, r.frame[:0]
+		r.train(r.frame)
+	} else {
+		r.frame, err = r.decompress(reserve(r.frame, int(length)), packed)
+	}
+	return r.checkFrame(length, offset, err)
+}
+
+// Starts decompressing frames until there are enough in flight
+// and returns the oldest one, in chunked mode
+func (r *FrameReader) readChunk() error {
+	r.releaseFrame()
+
+	for r.ended == nil && !r.chunks.full() {
+		var (
+			c   *chunk = r.chunks.get()
+			err error
+		)
+
+		if c.length, c.packed, c.stored, c.offset, err = r.readPacked(c.packed[:0]); err != nil || c.length == 0 {
+			r.chunks.put(c)
+			if r.ended = err; err == nil {
+				r.ended = io.EOF
+			}
+			break
+		}
+		r.chunks.start(c, (*chunk).decompress)
+	}
+
+	if len(r.chunks.pending) == 0 {
+		if r.ended == io.EOF {
+			return r.checkTrailer()
+		}
+		return r.ended
+	}
+
+	r.current = r.chunks.next()
+	r.frame = r.current.plain
+	return r.checkFrame(r.current.length, r.current.offset, r.current.err)
+}
+
+// Reads the lengths and the compressed data, or the plain data of a stored frame,
+// of the next frame into dst, which is replaced from the buffer pool if it is too small.
+// Returns a zero length after reading the trailer at the end of the stream.
+func (r *FrameReader) readPacked(dst []byte) (length uint64, packed []byte, stored bool, offset int64, err error) {
+	if length, err = binary.ReadUvarint(&r.source); err != nil {
+		return 0, dst, false, 0, unexpected(err)
+	}
+
+	if length == 0 {
+		_, err = io.ReadFull(&r.source, r.trailer[:])
+		return 0, dst, false, 0, unexpected(err)
+	}
+	if length > maxFrameSize {
+		return 0, dst, false, 0, CorruptInputError(r.source.offset)
+	}
+
+	size, err := binary.ReadUvarint(&r.source)
+	if err != nil {
+		return 0, dst, false, 0, unexpected(err)
+	}
+	if size > uint64(packedSize(int(length))) {
+		return 0, dst, false, 0, CorruptInputError(r.source.offset)
+	}
+	if size == 0 {
+		size, stored = length, true
+	}
+
+	offset = r.source.offset
+	dst = reserve(dst, int(size))[:size]
+	if _, err = io.ReadFull(&r.source, dst); err != nil {
+		return 0, dst, false, 0, unexpected(err)
+	}
+	return length, dst, stored, offset, nil
+}
+
+// Verifies the length of the decompressed frame and accounts for its data
+func (r *FrameReader) checkFrame(length uint64, offset int64, err error) error {
+	r.from = 0
+	if err != nil || uint64(len(r.frame)) != length {
+		r.frame = r.frame[:0]
+		return CorruptInputError(offset)
+	}
+
+	r.length += length
+	r.checksum.Write(r.frame)
+	return nil
+}
+
+// Verifies the trailer against the plain data
+func (r *FrameReader) checkTrailer() error {
+	if binary.LittleEndian.Uint64(r.trailer[:]) != r.length {
+		return ErrLength
+	}
+	if binary.LittleEndian.Uint32(r.trailer[8:]) != r.checksum.Sum32() {
+		return ErrChecksum
+	}
+	return io.EOF
+}
+
+// Returns the guess tables and the buffers to the buffer pool
+func (r *FrameReader) release() {
+	r.context.release()
+	r.releaseFrame()
+	r.chunks.release()
+	putBuffer(&r.input)
+	r.source.release()
+}
+
+// Drops the plain data of the current frame. It is returned to the buffer pool
+// unless it is that of the current chunk, which is freed instead.
+func (r *FrameReader) releaseFrame() {
+	if r.current != nil {
+		r.chunks.put(r.current)
+		r.current = nil
+		r.frame = nil
+	}
+	putBuffer(&r.frame)
+	r.from = 0
+}
+
+// Discards the FrameReader's state and makes it equivalent to
+// a new FrameReader with the same options over the provided io.Reader.
+func (r *FrameReader) Reset(reader io.Reader) error {
+	r.releaseFrame()
+	r.chunks.drain()
+	r.checksum.Reset()
+	r.source.reset(reader)
+	r.err, r.ended, r.length = nil, nil, 0
+
+	if r.header, r.err = readHeader(&r.source, r.dict); r.err != nil {
+		r.release()
+		return r.err
+	}
+
+	opts := r.header.opts
+	opts.Workers = r.workers
+	if r.header.flags&flagChunked != 0 {
+		r.chunks.init(opts)
+	} else {
+		r.init(opts)
+	}
+	return nil
+}
+
+// Reports an end of the stream in the middle of the framed format as unexpected
+func unexpected(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
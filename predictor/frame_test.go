@@ -0,0 +1,342 @@
+package predictor // import "github.com/spaskalev/misc/predictor"
+
+import (
+	"bytes"
+	iou "github.com/spaskalev/misc/ioutil"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+	"testing/iotest"
+)
+
+// Compresses the input in the framed format, flushing after every step bytes
+func frame(input []byte, step int) ([]byte, error) {
+	var (
+		buf bytes.Buffer
+		w   *FrameWriter = NewFrameWriter(&buf)
+	)
+
+	for len(input) > 0 {
+		if step > len(input) {
+			step = len(input)
+		}
+		if _, err := w.Write(input[:step]); err != nil {
+			return nil, err
+		}
+		if err := w.Flush(); err != nil {
+			return nil, err
+		}
+		input = input[step:]
+	}
+
+	err := w.Close()
+	return buf.Bytes(), err
+}
+
+func TestFrameSample(t *testing.T) {
+	framed, err := frame(input, len(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The sample output is contained as is in a single frame
+	if !bytes.Contains(framed, output) {
+		t.Errorf("Unexpected framed output %#x", framed)
+	}
+
+	r, err := NewFrameReader(bytes.NewReader(framed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := checkReader(r, input); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestFrameCycle(t *testing.T) {
+	var large []byte = make([]byte, frameSize*2+13)
+	for i := range large {
+		large[i] = byte(i * i >> 3)
+	}
+
+	for _, data := range append(testData, input, large) {
+		for _, step := range []int{1, 3, 8, 13, frameSize, len(data)} {
+			if step == 0 {
+				continue
+			}
+
+			framed, err := frame(data, step)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			r, err := NewFrameReader(iotest.HalfReader(bytes.NewReader(framed)))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			result, err := ioutil.ReadAll(r)
+			if err != nil {
+				t.Error("Unexpected error for step", step, err)
+			}
+			if !bytes.Equal(result, data) {
+				t.Error("Unexpected result for step", step, len(result), len(data))
+			}
+		}
+	}
+}
+
+func TestFrameReaderAvailable(t *testing.T) {
+	var data []byte = textCorpus(1 << 12)
+
+	framed, err := frame(data, 1<<10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewFrameReader(stalling(t, framed[:len(framed)-1]))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result := make([]byte, 2*len(data))
+	if count, err := r.Read(result); count != 1<<10 || err != nil || !bytes.Equal(result[:count], data[:count]) {
+		t.Error("Unexpected read", count, err)
+	}
+}
+
+func TestFrameReset(t *testing.T) {
+	var (
+		buf bytes.Buffer
+		w   *FrameWriter = NewFrameWriter(ioutil.Discard)
+	)
+
+	w.Write(input)
+	w.Reset(&buf)
+	w.Write(input)
+	w.Close()
+
+	framed, _ := frame(input, len(input))
+	if !bytes.Equal(buf.Bytes(), framed) {
+		t.Errorf("Unexpected framed output after reset %#x", buf.Bytes())
+	}
+
+	r, err := NewFrameReader(bytes.NewReader(framed[:len(framed)-1]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ioutil.ReadAll(r)
+	if err := r.Reset(bytes.NewReader(framed)); err != nil {
+		t.Fatal(err)
+	}
+	if result, err := ioutil.ReadAll(r); err != nil || !bytes.Equal(result, input) {
+		t.Error("Unexpected result after reset", result, err)
+	}
+}
+
+func TestFrameAllocs(t *testing.T) {
+	var (
+		plain  []byte = textCorpus(1000)
+		result []byte = make([]byte, len(plain))
+		buf    bytes.Buffer
+		w      *FrameWriter = NewFrameWriter(&buf)
+	)
+
+	w.Close()
+	r, err := NewFrameReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The frame, chunk and read buffers are drawn from the buffer pool and
+	// returned to it, leaving only the small arrays that escape to the
+	// underlying writer and reader. The bound allows for the pool dropping
+	// buffers, as it does with the race detector.
+	allocs := testing.AllocsPerRun(100, func() {
+		buf.Reset()
+		w.Reset(&buf)
+		w.Write(plain)
+		w.Close()
+		r.Reset(&buf)
+		io.ReadFull(r, result)
+		r.Read(result[:1])
+	})
+	if allocs > 12 {
+		t.Error("Unexpected allocations for a framed round trip", allocs)
+	}
+	if !bytes.Equal(result, plain) {
+		t.Error("Unexpected result of a framed round trip")
+	}
+}
+
+func TestFrameErrors(t *testing.T) {
+	framed, err := frame(input, len(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Returns a copy of the framed sample with a modified byte
+	modify := func(at int, value byte) []byte {
+		result := append([]byte{}, framed...)
+		result[at] = value
+		return result
+	}
+
+	if _, err := NewFrameReader(bytes.NewReader(input)); err != ErrHeader {
+		t.Error("Unexpected error for invalid data", err)
+	}
+	if _, err := NewFrameReader(bytes.NewReader(framed[:2])); err != ErrHeader {
+		t.Error("Unexpected error for a short header", err)
+	}
+	if _, err := NewFrameReader(bytes.NewReader(modify(len(magic), version+1))); err != ErrVersion {
+		t.Error("Unexpected error for an unsupported version", err)
+	}
+	if _, err := NewFrameReader(bytes.NewReader(modify(len(magic)+1, 0x80))); err != ErrHeader {
+		t.Error("Unexpected error for unknown flags", err)
+	}
+	if _, err := NewFrameReader(bytes.NewReader(modify(len(magic)+2, maxTableBits+1))); err != ErrHeader {
+		t.Error("Unexpected error for invalid options", err)
+	}
+	if _, err := NewFrameReader(bytes.NewReader(framed[:headerSize-1])); err != io.ErrUnexpectedEOF {
+		t.Error("Unexpected error for a truncated header", err)
+	}
+
+	var cases = []struct {
+		name string
+		data []byte
+		err  error
+	}{
+		// The frame's plain length does not match its compressed data
+		{"frame length", modify(headerSize, byte(len(input)-1)), CorruptInputError(headerSize + 2)},
+		// The trailer is modified
+		{"length", modify(len(framed)-trailerSize, 0), ErrLength},
+		{"checksum", modify(len(framed)-1, ^framed[len(framed)-1]), ErrChecksum},
+		// The end marker is missing
+		{"end", framed[:len(framed)-1-trailerSize], io.ErrUnexpectedEOF},
+	}
+
+	for _, c := range cases {
+		r, err := NewFrameReader(bytes.NewReader(c.data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ioutil.ReadAll(r); err != c.err {
+			t.Error("Unexpected error for", c.name, err)
+		}
+	}
+
+	// No prefix of the stream is valid
+	for i := headerSize; i < len(framed); i++ {
+		r, err := NewFrameReader(bytes.NewReader(framed[:i]))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ioutil.ReadAll(r); err == nil {
+			t.Error("Unexpected lack of error for prefix", i)
+		}
+	}
+}
+
+// Returns incompressible data
+func randomCorpus(size int) []byte {
+	var data []byte = make([]byte, size)
+	rand.New(rand.NewSource(1978)).Read(data)
+	return data
+}
+
+func TestFrameStored(t *testing.T) {
+	var (
+		random []byte = randomCorpus(2*storedSize + 13)
+		mixed  []byte = bytes.Join([][]byte{textCorpus(3 * frameSize), random[:5*frameSize], textCorpus(3 * frameSize)}, nil)
+	)
+
+	// Incompressible data is stored in as few frames as possible
+	framed, err := frame(random, len(random))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if overhead := len(framed) - len(random); overhead > headerSize+3*5+1+trailerSize {
+		t.Error("Unexpected overhead", overhead)
+	}
+
+	for _, opts := range []Options{{}, {ChunkSize: frameSize, Seekable: true}} {
+		for _, data := range [][]byte{random, mixed} {
+			framed, err := chunked(data, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(framed) > len(data)+len(data)/frameSize*16+64 {
+				t.Error("Unexpected expansion for", opts, len(framed)-len(data))
+			}
+
+			r, err := NewFrameReader(iotest.HalfReader(bytes.NewReader(framed)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result, err := ioutil.ReadAll(r); err != nil || !bytes.Equal(result, data) {
+				t.Error("Unexpected result for", opts, err)
+			}
+
+			if opts.Seekable {
+				s, err := NewSeekableReader(bytes.NewReader(framed), int64(len(framed)))
+				if err != nil {
+					t.Fatal(err)
+				}
+				result := make([]byte, 2*frameSize)
+				for _, at := range []int{frameSize / 2, 0, 3 * frameSize} {
+					if _, err := s.ReadAt(result, int64(at)); err != nil || !bytes.Equal(result, data[at:at+len(result)]) {
+						t.Error("Unexpected seekable result at", at, err)
+					}
+				}
+			}
+		}
+	}
+
+	// Stored frames interleave with flushed compressed frames
+	framed, err = frame(mixed, frameSize/3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewFrameReader(bytes.NewReader(framed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result, err := ioutil.ReadAll(r); err != nil || !bytes.Equal(result, mixed) {
+		t.Error("Unexpected result for flushed frames", err)
+	}
+}
+
+func TestFrameTransforms(t *testing.T) {
+	var (
+		data []byte        = textCorpus(1 << 16)
+		opts Options       = Options{ChunkSize: 1 << 12}
+		both iou.Transform = iou.Pipeline(CompressTransform(opts), DecompressTransform(opts))
+		buf  bytes.Buffer
+	)
+
+	// A compressing and decompressing pipeline restores the data on both sides
+	reader, err := both.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result, err := ioutil.ReadAll(reader); err != nil || !bytes.Equal(result, data) {
+		t.Error("Unexpected result on the reading side", err)
+	}
+
+	writer, err := both.NewWriter(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writer.Write(data)
+	if err := writer.Close(); err != nil || !bytes.Equal(buf.Bytes(), data) {
+		t.Error("Unexpected result on the writing side", err)
+	}
+
+	// Invalid options are reported
+	if _, err := CompressTransform(Options{TableBits: 99}).NewWriter(&buf); err != ErrOptions {
+		t.Error("Unexpected error for invalid options", err)
+	}
+	if _, err := DecompressTransform(opts).NewReader(bytes.NewReader(data)); err != ErrHeader {
+		t.Error("Unexpected error for an invalid header", err)
+	}
+}
@@ -0,0 +1,114 @@
+package predictor // import "github.com/spaskalev/misc/predictor"
+
+import (
+	"errors"
+	"runtime"
+)
+
+// Hash selects the function that builds the guess table index
+// as a sliding hash sum of the preceding characters.
+type Hash byte
+
+const (
+	// Hashes the previous 4 characters, or 3-and-a-bit for table sizes
+	// not divisible by 4. This is the RFC1978 hash at the default table size.
+	Order4 Hash = iota
+
+	// Hashes the previous 3 characters, or 2-and-a-bit for table sizes
+	// not divisible by 3.
+	Order3
+
+	// Hashes the previous 2 characters
+	Order2
+)
+
+// Returns the number of characters the hash is built from
+func (h Hash) order() uint {
+	return 4 - uint(h)
+}
+
+const (
+	// The table size bounds and default, as powers of two
+	minTableBits     = 12
+	maxTableBits     = 24
+	defaultTableBits = 16
+)
+
+// ErrOptions is returned when creating a compressor or a decompressor with invalid options
+var ErrOptions = errors.New("predictor: invalid options")
+
+// Options configure the predictor algorithm.
+//
+// The zero value selects the algorithm as specified in RFC1978.
+type Options struct {
+	// The guess table has 1 << TableBits entries, from 12 to 24.
+	// Zero selects the RFC1978 size of 16. Larger tables remember
+	// more contexts at the expense of memory and cache locality.
+	TableBits uint
+
+	// The hash function for indexing the guess table
+	Hash Hash
+
+	// Splits a framed stream into chunks of ChunkSize bytes that
+	// are compressed independently with an empty guess table, up to 16 MiB.
+	// This allows for processing chunks concurrently at the expense of
+	// compression ratio. Zero shares the guess table across the stream.
+	ChunkSize int
+
+	// The number of chunks that are processed concurrently.
+	// Zero selects runtime.GOMAXPROCS.
+	Workers int
+
+	// Appends an index of the chunks to a framed stream so that
+	// it can be read at random with a SeekableReader. Requires ChunkSize.
+	Seekable bool
+
+	// Seeds the guess table, including that of every chunk.
+	// It must match the table size, the hash function and the candidates.
+	Dictionary *Dictionary
+
+	// The number of guesses per guess table slot, 1, 2 or 4.
+	// Zero selects the RFC1978 single guess. More candidates predict
+	// more bytes at the expense of speed and a proportionally larger table.
+	Candidates int
+}
+
+// Returns the table size as a power of two, applying the default
+func (o Options) tableBits() uint {
+	if o.TableBits == 0 {
+		return defaultTableBits
+	}
+	return o.TableBits
+}
+
+// Returns the number of workers, applying the default
+func (o Options) workers() int {
+	if o.Workers == 0 {
+		return runtime.GOMAXPROCS(0)
+	}
+	return o.Workers
+}
+
+// Returns ErrOptions if the options are out of range
+func (o Options) check() error {
+	if bits := o.tableBits(); bits < minTableBits || bits > maxTableBits || o.Hash > Order2 {
+		return ErrOptions
+	}
+	if o.ChunkSize < 0 || o.ChunkSize > maxFrameSize || o.Workers < 0 || (o.Seekable && o.ChunkSize == 0) {
+		return ErrOptions
+	}
+	if c := o.candidates(); c != 1 && c != 2 && c != 4 {
+		return ErrOptions
+	}
+	if o.Dictionary != nil && !o.Dictionary.matches(o) {
+		return ErrOptions
+	}
+	return nil
+}
+
+// Returns the options that are checked for readers of framed streams,
+// which take the table size and hash function from the stream's header
+func (o Options) readerOptions() Options {
+	o.TableBits, o.Hash, o.Dictionary, o.Candidates = 0, 0, nil, 0
+	return o
+}
@@ -0,0 +1,167 @@
+package predictor // import "github.com/spaskalev/misc/predictor"
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+)
+
+// Every valid table size, hash function and number of candidates
+func allOptions() (result []Options) {
+	for tableBits := uint(minTableBits); tableBits <= maxTableBits; tableBits++ {
+		for _, hash := range []Hash{Order4, Order3, Order2} {
+			for _, candidates := range []int{0, 2, 4} {
+				result = append(result, Options{TableBits: tableBits, Hash: hash, Candidates: candidates})
+			}
+		}
+	}
+	return result
+}
+
+func TestOptionsCheck(t *testing.T) {
+	for _, opts := range []Options{{TableBits: minTableBits - 1}, {TableBits: maxTableBits + 1}, {Hash: Order2 + 1}, {Candidates: 3}, {Candidates: -1}} {
+		if _, err := NewWriterOptions(ioutil.Discard, opts); err != ErrOptions {
+			t.Error("Unexpected error for", opts, err)
+		}
+		if _, err := NewReaderOptions(bytes.NewReader(nil), opts); err != ErrOptions {
+			t.Error("Unexpected error for", opts, err)
+		}
+		if _, err := NewFrameWriterOptions(ioutil.Discard, opts); err != ErrOptions {
+			t.Error("Unexpected error for", opts, err)
+		}
+	}
+}
+
+func TestOptionsDefault(t *testing.T) {
+	var buf bytes.Buffer
+
+	w, err := NewWriterOptions(&buf, Options{TableBits: defaultTableBits, Hash: Order4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write(input)
+	w.Close()
+
+	if !bytes.Equal(buf.Bytes(), output) {
+		t.Errorf("Unexpected compressed output %#x", buf.Bytes())
+	}
+}
+
+func TestOptionsCycle(t *testing.T) {
+	var data []byte = textCorpus(1 << 14)
+
+	for _, opts := range allOptions() {
+		var buf bytes.Buffer
+
+		w, err := NewWriterOptions(&buf, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(data)
+		w.Close()
+
+		r, err := NewReaderOptions(&buf, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result, err := ioutil.ReadAll(r); err != nil || !bytes.Equal(result, data) {
+			t.Error("Unexpected result for", opts, err)
+		}
+	}
+}
+
+func TestOptionsFrame(t *testing.T) {
+	var data []byte = textCorpus(1 << 14)
+
+	for _, opts := range allOptions() {
+		var buf bytes.Buffer
+
+		w, err := NewFrameWriterOptions(&buf, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(data)
+		w.Close()
+
+		// The options are taken from the header
+		r, err := NewFrameReader(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result, err := ioutil.ReadAll(r); err != nil || !bytes.Equal(result, data) {
+			t.Error("Unexpected result for", opts, err)
+		}
+	}
+}
+
+// Returns pseudo-random text built from a small vocabulary
+func textCorpus(size int) []byte {
+	var (
+		words []string = []string{"the", "predictor", "compression", "protocol", "guess", "table",
+			"hash", "block", "of", "and", "is", "a", "to", "data", "stream", "byte", "header"}
+		random *rand.Rand = rand.New(rand.NewSource(1978))
+		buf    bytes.Buffer
+	)
+
+	for buf.Len() < size {
+		buf.WriteString(words[random.Intn(len(words))])
+		if random.Intn(12) == 0 {
+			buf.WriteString(".\n")
+		} else {
+			buf.WriteByte(' ')
+		}
+	}
+	return buf.Bytes()[:size]
+}
+
+// Returns pseudo-random binary records with a fixed layout and slowly changing fields
+func binaryCorpus(size int) []byte {
+	var (
+		random *rand.Rand = rand.New(rand.NewSource(1978))
+		buf    bytes.Buffer
+		record [16]byte
+	)
+
+	for i := 0; buf.Len() < size; i++ {
+		record[0], record[1] = byte(i), byte(i>>8)
+		record[4] = byte(random.Intn(4))
+		record[8], record[9] = byte(random.Intn(256)), byte(random.Intn(256))
+		record[12] = 0xff
+		buf.Write(record[:])
+	}
+	return buf.Bytes()[:size]
+}
+
+// Compares the compression ratio and speed of the table sizes and hash functions
+func BenchmarkOptions(b *testing.B) {
+	var corpora = []struct {
+		name string
+		data []byte
+	}{
+		{"text", textCorpus(1 << 20)},
+		{"binary", binaryCorpus(1 << 20)},
+	}
+
+	for _, corpus := range corpora {
+		for _, tableBits := range []uint{12, 16, 20, 24} {
+			for _, hash := range []Hash{Order4, Order3, Order2} {
+				opts := Options{TableBits: tableBits, Hash: hash}
+				b.Run(fmt.Sprintf("%s/bits=%d/order=%d", corpus.name, tableBits, hash.order()), func(b *testing.B) {
+					var buf bytes.Buffer
+					w, _ := NewWriterOptions(&buf, opts)
+
+					b.SetBytes(int64(len(corpus.data)))
+					for i := 0; i < b.N; i++ {
+						buf.Reset()
+						w.Reset(&buf)
+						w.Write(corpus.data)
+						w.Close()
+					}
+					b.ReportMetric(float64(buf.Len())/float64(len(corpus.data)), "ratio")
+				})
+			}
+		}
+	}
+}
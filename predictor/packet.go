@@ -0,0 +1,243 @@
+package predictor // import "github.com/spaskalev/misc/predictor"
+
+// The packet API implements the Predictor variants from RFC1978
+//
+//	type 1: length (2 bytes, big endian), data, FCS (2 bytes, little endian)
+//	type 2: length (1 byte), data, FCS (2 bytes, little endian)
+//
+// The length is that of the uncompressed data. In type 1 packets its highest
+// bit is raised if the data is compressed, and data is sent uncompressed if
+// compressing it would not make it shorter. Type 2 packets are limited
+// to 255 bytes of data, which is always compressed. The FCS is the 16-bit
+// PPP frame check sequence from RFC1662 over the length, with the highest
+// bit cleared, and the uncompressed data.
+//
+// RFC1978 defines the type 1 framing but does not specify one for type 2.
+// The type 2 framing above is this package's own and may not interoperate
+// with other implementations.
+//
+// The guess table is shared across the packets of a link. After a decoding
+// error the peer should send a CCP Reset-Request and both sides should Reset
+// on the Reset-Ack to bring their guess tables back in sync.
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// Type selects the Predictor variant for packets
+type Type byte
+
+const (
+	// Predictor type 1
+	Type1 Type = 1 + iota
+
+	// Predictor type 2, with an 8-bit length
+	Type2
+)
+
+// Returns the size of the length field in a packet
+func (t Type) lengthSize() int {
+	if t == Type2 {
+		return 1
+	}
+	return 2
+}
+
+// Returns the largest uncompressed data that fits in a packet's length
+func (t Type) maxSize() int {
+	if t == Type2 {
+		return 1<<8 - 1
+	}
+	return 1<<15 - 1
+}
+
+const (
+	// Marks a compressed type 1 packet in the first byte of its length
+	packetCompressed = 1 << 7
+
+	// The initial and the residual values of the FCS as per RFC1662
+	initialFCS = 0xffff
+	goodFCS    = 0xf0b8
+)
+
+var (
+	// ErrPacketSize is returned when encoding data that does not fit in a packet
+	ErrPacketSize = errors.New("predictor: packet too large")
+
+	// ErrPacket is returned when decoding a malformed packet
+	ErrPacket = errors.New("predictor: invalid packet")
+
+	// ErrPacketFCS is returned when decoding a packet with an invalid frame check sequence
+	ErrPacketFCS = errors.New("predictor: invalid packet frame check sequence")
+)
+
+// The lookup table for the FCS, computed with the reversed CCITT polynomial
+var fcsTable [256]uint16
+
+func init() {
+	for i := range fcsTable {
+		var value uint16 = uint16(i)
+		for bit := 0; bit < 8; bit++ {
+			if value&1 > 0 {
+				value = (value >> 1) ^ 0x8408
+			} else {
+				value >>= 1
+			}
+		}
+		fcsTable[i] = value
+	}
+}
+
+// Updates a PPP frame check sequence with the given data
+func fcs16(fcs uint16, data []byte) uint16 {
+	for _, value := range data {
+		fcs = (fcs >> 8) ^ fcsTable[byte(fcs)^value]
+	}
+	return fcs
+}
+
+// Updates the guess table with data as if compressing it, without any output
+func (ctx *context) train(data []byte) {
+	for _, current := range data {
+		if ctx.candidates > 1 {
+			ctx.lookup(current)
+		} else {
+			ctx.table[ctx.hash] = current
+		}
+		ctx.update(current)
+	}
+}
+
+// A PacketEncoder compresses PPP packets with Predictor as per RFC1978
+type PacketEncoder struct {
+	context
+	kind Type
+}
+
+// Returns a new PacketEncoder for the given Predictor type.
+// Returns ErrOptions for an unknown type.
+func NewPacketEncoder(kind Type) (*PacketEncoder, error) {
+	if kind != Type1 && kind != Type2 {
+		return nil, ErrOptions
+	}
+
+	var e PacketEncoder
+	e.init(Options{})
+	e.kind = kind
+	return &e, nil
+}
+
+// Encodes src as a packet, appending it to dst and returning the result.
+// Returns ErrPacketSize if src is too large for a packet.
+func (e *PacketEncoder) EncodePacket(dst []byte, src []byte) ([]byte, error) {
+	if len(src) > e.kind.maxSize() {
+		return dst, ErrPacketSize
+	}
+
+	var (
+		buffer [2]byte
+		size   int = e.kind.lengthSize()
+		start  int = len(dst)
+	)
+
+	binary.BigEndian.PutUint16(buffer[:], uint16(len(src)))
+	length := buffer[2-size:]
+
+	dst = append(dst, length...)
+	dst = e.compress(dst, src)
+
+	switch {
+	case e.kind == Type2:
+		// Type 2 data is always compressed
+	case len(dst)-start-size < len(src):
+		dst[start] |= packetCompressed
+	default:
+		// Send the data uncompressed, the guess table is already updated
+		dst = append(dst[:start+size], src...)
+	}
+
+	fcs := ^fcs16(fcs16(initialFCS, length), src)
+	return append(dst, byte(fcs), byte(fcs>>8)), nil
+}
+
+// Clears the guess table, as on receiving a CCP Reset-Request
+func (e *PacketEncoder) Reset() {
+	e.reset()
+}
+
+// A PacketDecoder decompresses PPP packets with Predictor as per RFC1978
+type PacketDecoder struct {
+	context
+	kind Type
+	err  error
+}
+
+// Returns a new PacketDecoder for the given Predictor type.
+// Returns ErrOptions for an unknown type.
+func NewPacketDecoder(kind Type) (*PacketDecoder, error) {
+	if kind != Type1 && kind != Type2 {
+		return nil, ErrOptions
+	}
+
+	var d PacketDecoder
+	d.init(Options{})
+	d.kind = kind
+	return &d, nil
+}
+
+// Decodes the packet in src, appending its data to dst and returning the result.
+//
+// An invalid packet leaves the guess table out of sync with the encoder's,
+// so the error is returned for every later packet until a Reset.
+func (d *PacketDecoder) DecodePacket(dst []byte, src []byte) ([]byte, error) {
+	var size int = d.kind.lengthSize()
+
+	if d.err != nil {
+		return dst, d.err
+	}
+	if len(src) < size+2 {
+		d.err = ErrPacket
+		return dst, d.err
+	}
+
+	var (
+		buffer     [2]byte
+		length     []byte = buffer[2-size:]
+		compressed bool   = d.kind == Type2 || src[0]&packetCompressed > 0
+		data       []byte = src[size : len(src)-2]
+		start      int    = len(dst)
+		err        error
+	)
+
+	// Clear the compressed flag of type 1 packets
+	copy(length, src)
+	if d.kind == Type1 {
+		length[0] &^= packetCompressed
+	}
+
+	if compressed {
+		dst, err = d.decompress(dst, data)
+	} else {
+		dst = append(dst, data...)
+		d.train(data)
+	}
+
+	if err != nil || len(dst)-start != int(binary.BigEndian.Uint16(buffer[:])) {
+		d.err = ErrPacket
+		return dst[:start], d.err
+	}
+
+	fcs := fcs16(fcs16(fcs16(initialFCS, length), dst[start:]), src[len(src)-2:])
+	if fcs != goodFCS {
+		d.err = ErrPacketFCS
+		return dst[:start], d.err
+	}
+	return dst, nil
+}
+
+// Clears the guess table and any error, as on receiving a CCP Reset-Ack
+func (d *PacketDecoder) Reset() {
+	d.reset()
+	d.err = nil
+}
@@ -0,0 +1,185 @@
+package predictor // import "github.com/spaskalev/misc/predictor"
+
+import (
+	"bytes"
+	"testing"
+)
+
+// The Predictor types and their sample packets
+var packetTypes = []struct {
+	kind   Type
+	sample []byte
+}{
+	{Type1, packet1},
+	{Type2, packet2},
+}
+
+func newPacketCodec(t *testing.T, kind Type) (*PacketEncoder, *PacketDecoder) {
+	encoder, err := NewPacketEncoder(kind)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoder, err := NewPacketDecoder(kind)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return encoder, decoder
+}
+
+func TestPacketType(t *testing.T) {
+	if _, err := NewPacketEncoder(Type2 + 1); err != ErrOptions {
+		t.Error("Unexpected error for an unknown type", err)
+	}
+	if _, err := NewPacketDecoder(0); err != ErrOptions {
+		t.Error("Unexpected error for an unknown type", err)
+	}
+}
+
+func TestPacketSample(t *testing.T) {
+	for _, p := range packetTypes {
+		encoder, decoder := newPacketCodec(t, p.kind)
+
+		result, err := encoder.EncodePacket([]byte{0xff}, input)
+		if err != nil || !bytes.Equal(result, append([]byte{0xff}, p.sample...)) {
+			t.Errorf("Unexpected type %d packet %#x %v", p.kind, result, err)
+		}
+
+		result, err = decoder.DecodePacket([]byte{0xff}, p.sample)
+		if err != nil || !bytes.Equal(result, append([]byte{0xff}, input...)) {
+			t.Errorf("Unexpected type %d data %#x %v", p.kind, result, err)
+		}
+	}
+}
+
+func TestPacketUncompressed(t *testing.T) {
+	var expected = map[Type][]byte{
+		// Data that would expand is sent uncompressed in type 1 packets
+		Type1: {0x00, 0x03, 0x01, 0x02, 0x03, 0x4d, 0xb1},
+		// and compressed in type 2 packets
+		Type2: {0x03, 0x00, 0x01, 0x02, 0x03, 0xe4, 0x82},
+	}
+
+	for kind, packet := range expected {
+		encoder, decoder := newPacketCodec(t, kind)
+
+		result, err := encoder.EncodePacket(nil, []byte{1, 2, 3})
+		if err != nil || !bytes.Equal(result, packet) {
+			t.Errorf("Unexpected type %d packet %#x %v", kind, result, err)
+		}
+
+		result, err = decoder.DecodePacket(nil, packet)
+		if err != nil || !bytes.Equal(result, []byte{1, 2, 3}) {
+			t.Error("Unexpected type", kind, "data", result, err)
+		}
+	}
+}
+
+func TestPacketSequence(t *testing.T) {
+	var data []byte = textCorpus(1 << 14)
+
+	for _, p := range packetTypes {
+		var (
+			packets          [][]byte
+			encoder, decoder = newPacketCodec(t, p.kind)
+		)
+
+		// Packets of varying sizes, some of which are sent uncompressed
+		for i, size := 0, 1; i < len(data); i, size = i+size, size*3%p.kind.maxSize()+1 {
+			if i+size > len(data) {
+				size = len(data) - i
+			}
+			packet, err := encoder.EncodePacket(nil, data[i:i+size])
+			if err != nil {
+				t.Fatal(err)
+			}
+			packets = append(packets, packet)
+		}
+
+		var result []byte
+		for _, packet := range packets {
+			var err error
+			if result, err = decoder.DecodePacket(result, packet); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if !bytes.Equal(result, data) {
+			t.Error("Unexpected decoded data for type", p.kind)
+		}
+	}
+}
+
+func TestPacketErrors(t *testing.T) {
+	for _, p := range packetTypes {
+		encoder, _ := newPacketCodec(t, p.kind)
+		if _, err := encoder.EncodePacket(nil, make([]byte, p.kind.maxSize()+1)); err != ErrPacketSize {
+			t.Error("Unexpected error for a large packet", err)
+		}
+
+		var (
+			sample []byte = p.sample
+			size   int    = p.kind.lengthSize()
+		)
+
+		// Returns a copy of the sample packet with a modified byte
+		modify := func(at int, value byte) []byte {
+			result := append([]byte{}, sample...)
+			result[at] = value
+			return result
+		}
+
+		for name, c := range map[string]struct {
+			packet []byte
+			err    error
+		}{
+			"short":     {sample[:size+1], ErrPacket},
+			"length":    {modify(size-1, 0x37), ErrPacket},
+			"truncated": {append(append([]byte{}, sample[:size+2]...), sample[len(sample)-2:]...), ErrPacket},
+			"fcs":       {modify(len(sample)-1, 0), ErrPacketFCS},
+			"data":      {modify(size+1, 0x42), ErrPacketFCS},
+		} {
+			_, d := newPacketCodec(t, p.kind)
+			if result, err := d.DecodePacket([]byte{0xff}, c.packet); err != c.err || !bytes.Equal(result, []byte{0xff}) {
+				t.Error("Unexpected result for type", p.kind, name, result, err)
+			}
+
+			// The error is sticky until a reset
+			if _, err := d.DecodePacket(nil, sample); err != c.err {
+				t.Error("Unexpected error after an error for type", p.kind, name, err)
+			}
+			d.Reset()
+			if result, err := d.DecodePacket(nil, sample); err != nil || !bytes.Equal(result, input) {
+				t.Error("Unexpected result after a reset for type", p.kind, name, result, err)
+			}
+		}
+	}
+
+	// Type 1 specific errors
+	_, d := newPacketCodec(t, Type1)
+	if _, err := d.DecodePacket(nil, append([]byte{0x00}, packet1[1:]...)); err != ErrPacket {
+		t.Error("Unexpected error for a missing compressed flag", err)
+	}
+	d.Reset()
+	if _, err := d.DecodePacket(nil, []byte{0x00, 0x00, 0xff, 0xff}); err != ErrPacketFCS {
+		t.Error("Unexpected error for an empty packet", err)
+	}
+}
+
+func TestPacketReset(t *testing.T) {
+	for _, p := range packetTypes {
+		encoder, decoder := newPacketCodec(t, p.kind)
+
+		encoder.EncodePacket(nil, input)
+
+		// Both sides reset, as on a CCP Reset-Request and Reset-Ack
+		encoder.Reset()
+		decoder.Reset()
+
+		result, _ := encoder.EncodePacket(nil, input)
+		if !bytes.Equal(result, p.sample) {
+			t.Errorf("Unexpected type %d packet after a reset %#x", p.kind, result)
+		}
+		if result, err := decoder.DecodePacket(nil, result); err != nil || !bytes.Equal(result, input) {
+			t.Error("Unexpected type", p.kind, "data after a reset", result, err)
+		}
+	}
+}
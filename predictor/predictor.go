@@ -3,6 +3,7 @@
 package predictor // import "github.com/spaskalev/misc/predictor"
 
 import (
+	"errors"
 	bits "github.com/spaskalev/bits"
 	iou "github.com/spaskalev/misc/ioutil"
 	"io"
@@ -10,9 +11,34 @@ import (
 
 // The context struct contains the predictor's algorithm guess table
 // and the current value of its input/output hash
+//
+// The guess table is drawn from the shared buffer pool. It can be returned
+// to the pool when the context is no longer used and is drawn again on reset.
 type context struct {
-	table [1 << 16]byte
-	hash  uint16
+	table []byte
+	size  int
+	hash  uint32
+	mask  uint32
+	shift uint
+	dict  *Dictionary
+
+	// The number of guesses per slot and the code bytes in candidates mode
+	candidates int
+	codes      codes
+
+	counters counters
+}
+
+// Sizes the guess table and selects the hash function from the options
+func (ctx *context) init(opts Options) {
+	if ctx.size != opts.tableSize() {
+		ctx.release()
+		ctx.size = opts.tableSize()
+	}
+	ctx.mask, ctx.shift = uint32(1)<<opts.tableBits()-1, opts.tableBits()/opts.Hash.order()
+	ctx.candidates = opts.candidates()
+	ctx.dict = opts.Dictionary
+	ctx.reset()
 }
 
 // The following hash code is the heart of the algorithm:
@@ -20,8 +46,116 @@ type context struct {
 // characters which will be used to index the guess table.
 // A better hash function would result in additional compression,
 // at the expense of time.
+//
+// The number of characters depends on the selected Hash and the table size.
+// The default of a 16-bit table with the Order4 hash is the one from RFC1978.
 func (ctx *context) update(val byte) {
-	ctx.hash = (ctx.hash << 4) ^ uint16(val)
+	ctx.hash = ((ctx.hash << ctx.shift) ^ uint32(val)) & ctx.mask
+}
+
+// Compresses a block of up to 8 bytes by appending
+// its prediction header and any mispredicted bytes to dst
+func (ctx *context) encode(dst []byte, block []byte) []byte {
+	if ctx.candidates > 1 {
+		return ctx.encodeCandidates(dst, block)
+	}
+
+	var (
+		header int  = len(dst)
+		flags  byte = 0
+	)
+
+	dst = append(dst, 0)
+	for i, current := range block {
+		if ctx.table[ctx.hash] == current {
+			// Guess was right - don't output
+			flags |= 1 << uint(i)
+		} else {
+			// Guess was wrong, output char
+			ctx.counters.guess(ctx.table[ctx.hash], current)
+			ctx.table[ctx.hash] = current
+			dst = append(dst, current)
+		}
+		ctx.update(current)
+	}
+	dst[header] = flags
+	ctx.counters.block(bits.Hamming(flags), len(block))
+
+	return dst
+}
+
+// Returns the size of the data that follows the flags of a block of the given length.
+// Returns false if data ends before the size is known, which is only possible
+// in candidates mode.
+func (ctx *context) blockSize(flags byte, data []byte, length int) (int, bool) {
+	if ctx.candidates > 1 {
+		size, literals, ok := ctx.codeSize(flags, data, length)
+		return size + literals, ok
+	}
+	return length - bits.Hamming(flags&byte(1<<uint(length)-1)), true
+}
+
+// Returns the length of a partial block from its flags and the data that follows them,
+// which is the largest one that matches the size of the data and has no predicted
+// bytes beyond it. Returns zero if there is no such length as the block is truncated.
+func (ctx *context) blockLength(flags byte, data []byte) int {
+	for length := 8; length > 0 && flags>>uint(length) == 0; length-- {
+		if size, ok := ctx.blockSize(flags, data, length); ok && size == len(data) {
+			return length
+		}
+	}
+	return 0
+}
+
+// Decompresses a block of the given length from its prediction header and the data
+// that follows it, appending the result to dst. The data must match the block's size.
+func (ctx *context) decode(dst []byte, flags byte, literals []byte, length int) []byte {
+	if ctx.candidates > 1 {
+		return ctx.decodeCandidates(dst, flags, literals, length)
+	}
+
+	// Walk the block, filling in the predicted blanks and updating the guess table
+	for i := 0; i < length; i++ {
+		var current byte
+		if (flags & (1 << uint(i))) > 0 {
+			// Guess succeeded, fill in from the table
+			current = ctx.table[ctx.hash]
+		} else {
+			// Guess failed, take the next read byte and update the table
+			current, literals = literals[0], literals[1:]
+			ctx.table[ctx.hash] = current
+		}
+		dst = append(dst, current)
+		ctx.update(current)
+	}
+
+	return dst
+}
+
+// Returns the guess table to the buffer pool until the next reset
+func (ctx *context) release() {
+	if ctx.table != nil {
+		iou.PutBuffer(ctx.table)
+		ctx.table = nil
+	}
+}
+
+// Clears the guess table, or seeds it from the dictionary, the hash and the counters
+func (ctx *context) reset() {
+	ctx.hash, ctx.counters = 0, counters{}
+	ctx.codes.reset()
+	if ctx.table == nil && ctx.size > 0 {
+		ctx.table = iou.GetBuffer(ctx.size)
+	}
+	if ctx.dict != nil {
+		copy(ctx.table, ctx.dict.table)
+		ctx.counters.occupied = ctx.dict.occupied
+		return
+	}
+
+	for i := range ctx.table {
+		ctx.table[i] = 0
+	}
 }
 
 // Returns an io.Writer implementation that wraps the provided io.Writer
@@ -30,132 +164,318 @@ func (ctx *context) update(val byte) {
 // It can buffer data as the predictor mandates 8-byte blocks with a header.
 // A call with no data will force a flush.
 func Compressor(writer io.Writer) io.Writer {
-	var cmp compressor
-	cmp.target = writer
-	return iou.SizedWriter(&cmp, 8)
+	return compressor{NewWriter(writer)}
 }
 
+// Maps the nil-write flush convention of Compressor onto a Writer
 type compressor struct {
+	*Writer
+}
+
+func (c compressor) Write(data []byte) (int, error) {
+	if data == nil {
+		return 0, c.Flush()
+	}
+	return c.Writer.Write(data)
+}
+
+// The number of blocks that are compressed before writing to the underlying writer
+const writeBlocks = 512
+
+// ErrClosed is returned when writing to a closed Writer
+var ErrClosed = errors.New("predictor: write to closed writer")
+
+// ErrFlushed is returned when writing to a Writer after a Flush of a partial block,
+// which ends the stream in the bare RFC1978 format
+var ErrFlushed = errors.New("predictor: write after a partial flush")
+
+// A Writer compresses the data written to it according to the predictor
+// algorithm and writes the result to an underlying io.Writer.
+//
+// Data is buffered until a full 8-byte block, or a group of blocks in candidates
+// mode, is available. Flush compresses any pending data. In the bare RFC1978 format
+// a partial block can only end the stream, so Write returns ErrFlushed after it.
+type Writer struct {
 	context
 	target io.Writer
+	err    error
+	out    uint64
+	ended  bool
+
+	// Input that does not yet fill a complete block or group
+	pending [groupBlocks * 8]byte
+	length  int
+
+	// Scratch space for compressed blocks
+	buffer [writeBlocks * maxBlockSize]byte
+}
+
+// Returns a new Writer that compresses data to the provided io.Writer
+func NewWriter(writer io.Writer) *Writer {
+	w, _ := NewWriterOptions(writer, Options{})
+	return w
+}
+
+// Returns a new Writer with the given options that compresses data to the provided io.Writer.
+// The same options must be used for decompressing the data.
+func NewWriterOptions(writer io.Writer, opts Options) (*Writer, error) {
+	if err := opts.check(); err != nil {
+		return nil, err
+	}
+
+	var w Writer
+	w.init(opts)
+	w.target = writer
+	return &w, nil
 }
 
-// Note: this method does not implement the full io.Writer's Write() semantics
-func (ctx *compressor) Write(data []byte) (int, error) {
+// Implements io.Writer
+//
+// Once the underlying writer fails, Write, Flush and Close keep returning its error.
+func (w *Writer) Write(data []byte) (int, error) {
 	var (
-		blockSize  int = 8
-		datalength int = len(data)
+		total int
+		unit  int = w.unit()
 	)
 
-	if datalength == 0 {
-		return 0, nil
+	if w.err != nil {
+		return 0, w.err
+	}
+	if w.ended {
+		return 0, ErrFlushed
 	}
 
-	if datalength < blockSize {
-		blockSize = datalength
+	// Complete a pending block first
+	if w.length > 0 {
+		count := copy(w.pending[w.length:unit], data)
+		w.length += count
+		data, total = data[count:], count
+
+		if w.length < unit {
+			return total, nil
+		}
+
+		w.length = 0
+		if w.err = w.write(w.encodeBlocks(w.buffer[:0], w.pending[:unit])); w.err != nil {
+			return total, w.err
+		}
 	}
 
-	var buf []byte = make([]byte, 1, blockSize+1)
-	for block := 0; block < datalength/blockSize; block++ {
-		for i := 0; i < blockSize; i++ {
-			var current byte = data[(block*blockSize)+i]
-			if ctx.table[ctx.hash] == current {
-				// Guess was right - don't output
-				buf[0] |= 1 << uint(i)
-			} else {
-				// Guess was wrong, output char
-				ctx.table[ctx.hash] = current
-				buf = append(buf, current)
-			}
-			ctx.update(current)
+	// Compress full blocks, in batches that fit the scratch space
+	for len(data) >= unit {
+		var (
+			output []byte = w.buffer[:0]
+			count  int
+		)
+
+		for ; count+unit <= len(data) && count < writeBlocks*8; count += unit {
+			output = w.encodeBlocks(output, data[count:count+unit])
 		}
 
-		if c, err := ctx.target.Write(buf); err != nil {
-			return (block * blockSize) + c, err
+		if w.err = w.write(output); w.err != nil {
+			return total, w.err
 		}
+		data, total = data[count:], total+count
+	}
+
+	// Stage the remaining data
+	w.length = copy(w.pending[:], data)
+	return total + w.length, nil
+}
+
+// Writes to the underlying writer, treating short writes as errors
+func (w *Writer) write(data []byte) error {
+	count, err := iou.WriteFull(w.target, data)
+	w.out += uint64(count)
+	return err
+}
 
-		// Reset the flags and buffer for the next iteration
-		buf, buf[0] = buf[:1], 0
+// Compresses and writes any pending partial block to the underlying writer,
+// which ends the stream. It does nothing if there is no pending data.
+func (w *Writer) Flush() error {
+	if w.err != nil || w.length == 0 {
+		return w.err
 	}
 
-	return datalength, nil
+	// The partial block also ends its group of blocks,
+	// whose code bytes were written from the scratch space
+	w.err = w.write(w.encodeBlocks(w.buffer[:0], w.pending[:w.length]))
+	w.length, w.ended = 0, true
+	w.codes.reset()
+	return w.err
+}
+
+// Flushes the Writer and prevents further writes.
+// It does not close the underlying writer.
+//
+// The guess table is returned to the buffer pool, even if the flush fails,
+// until the Writer is reset.
+func (w *Writer) Close() error {
+	if w.err == ErrClosed {
+		return nil
+	}
+
+	err := w.Flush()
+	w.release()
+	if err != nil {
+		return err
+	}
+
+	w.err = ErrClosed
+	return nil
+}
+
+// Discards the Writer's state and makes it equivalent to
+// a new Writer with the same options over the provided io.Writer.
+func (w *Writer) Reset(writer io.Writer) {
+	w.context.reset()
+	w.target, w.err, w.length, w.out, w.ended = writer, nil, 0, 0, false
 }
 
 // Returns an io.Reader implementation that wraps the provided io.Reader
 // and decompresses data according to the predictor algorithm
 func Decompressor(reader io.Reader) io.Reader {
-	var dcmp decompressor
-	dcmp.source = reader
-	return iou.SizedReader(&dcmp, 8)
+	return NewReader(reader)
 }
 
-type decompressor struct {
+// A Reader decompresses data from an underlying io.Reader
+// according to the predictor algorithm.
+//
+// A stream that ends in the middle of a block results in io.ErrUnexpectedEOF.
+// The guess table and the buffer over the source are returned to the buffer pool
+// once the stream ends or fails, until the Reader is reset.
+// As the bare RFC1978 format carries no length, a stream that was cut
+// such that its last block looks like a valid partial block can not be
+// told apart from a complete one.
+type Reader struct {
 	context
-	source io.Reader
+	source sourceReader
+	err    error
+
+	// Decompressed data that is not yet returned
+	buffer   [8]byte
+	from, to int
+
+	// Scratch space for a compressed block
+	input [maxBlockSize]byte
 }
 
-// Note: this method does not implement the full io.Reader's Read() semantics
-func (ctx *decompressor) Read(output []byte) (int, error) {
+// Returns a new Reader that decompresses data from the provided io.Reader
+func NewReader(reader io.Reader) *Reader {
+	r, _ := NewReaderOptions(reader, Options{})
+	return r
+}
+
+// Returns a new Reader with the given options that decompresses data from the provided io.Reader.
+// The options must match the ones used for compressing the data.
+func NewReaderOptions(reader io.Reader, opts Options) (*Reader, error) {
+	if err := opts.check(); err != nil {
+		return nil, err
+	}
+
+	var r Reader
+	r.init(opts)
+	r.source.reset(reader)
+	return &r, nil
+}
+
+// Implements io.Reader
+func (r *Reader) Read(output []byte) (int, error) {
+	var total int
+
+	for len(output) > 0 {
+		// Reply with the decompressed data if there is any
+		if r.from < r.to {
+			count := copy(output, r.buffer[r.from:r.to])
+			r.from += count
+			output, total = output[count:], total+count
+			continue
+		}
+
+		// Return what is available rather than wait for more
+		// once the next block may need another read from the source
+		if r.err != nil || (total > 0 && r.source.buffered() < maxBlockSize) {
+			break
+		}
+
+		// Decompress a buffered block straight into the output. It is a full one,
+		// as a partial block can only be the last one and is shorter than maxBlockSize.
+		if len(output) >= 8 && r.source.buffered() >= maxBlockSize {
+			block := r.source.peek()[:maxBlockSize]
+			if size, ok := r.blockSize(block[0], block[1:], 8); ok && 1+size <= len(block) {
+				count := len(r.decode(output[:0], block[0], block[1:1+size], 8))
+				r.source.discard(1 + size)
+				output, total = output[count:], total+count
+				continue
+			}
+		}
+
+		if r.err = r.block(); r.err != nil {
+			r.release()
+		}
+	}
+
+	if total > 0 {
+		return total, nil
+	}
+	return 0, r.err
+}
+
+// Reads and decompresses the next block into the buffer
+func (r *Reader) block() error {
+	// Read the next prediction header, a clean end of the stream is only possible here
+	if _, err := io.ReadFull(&r.source, r.input[:1]); err != nil {
+		return err
+	}
+
+	// Read the bytes that follow, one at a time while the codes are incomplete
 	var (
-		err                          error
-		buffer                       []byte = make([]byte, 8)
-		flags                        byte
-		predicted, rc, total, copied int
+		flags  byte = r.input[0]
+		count  int
+		length int = 8
+		err    error
 	)
+	for {
+		size, ok := r.blockSize(flags, r.input[1:1+count], length)
+		if !ok {
+			size = count + 1
+		}
 
-	// Read the next prediction header
-readHeader:
-	rc, err = ctx.source.Read(buffer[:1])
-	// Fail on error unless it is EOF
-	if err != nil && err != io.EOF {
-		return total, err
-	} else if rc == 0 {
-		return total, err
-	}
-
-	// Copy the prediction header and calculate the number of subsequent bytes to read
-	flags = buffer[0]
-	predicted = bits.Hamming(flags)
-
-	// Read the non-predicted bytes and place them in the end of the buffer
-	rc, err = ctx.source.Read(buffer[predicted:])
-retryData:
-	if (rc < (8 - predicted)) && err == nil {
-		// Retry the read if we have fewer bytes than what the prediction header indicates
-		var r int
-		r, err = ctx.source.Read(buffer[predicted+rc:])
-		rc += r
-		goto retryData
-	} // Continue on any error, try to decompress and return it along the result
-
-	// rc now contains the amount of actual bytes in this cycle (usually 8)
-	rc += predicted
-
-	// Walk the buffer, filling in the predicted blanks,
-	// relocating read bytes and and updating the guess table
-	for i, a := 0, predicted; i < rc; i++ {
-		if (flags & (1 << uint(i))) > 0 {
-			// Guess succeeded, fill in from the table
-			buffer[i] = ctx.table[ctx.hash]
-		} else {
-			// Relocate a read byte and advance the read byte index
-			buffer[i], a = buffer[a], a+1
-			// Guess failed, update the table
-			ctx.table[ctx.hash] = buffer[i]
+		var n int
+		n, err = io.ReadFull(&r.source, r.input[1+count:1+size])
+		count += n
+		if ok || err != nil {
+			break
 		}
-		// Update the hash
-		ctx.update(buffer[i])
 	}
 
-	// Copy the decompressed data to the output and accumulate the count
-	copied = copy(output, buffer[:rc])
-	total += copied
-
-	// Loop for another pass if there is available space in the output
-	output = output[copied:]
-	if len(output) > 0 && err == nil {
-		goto readHeader
+	switch err {
+	case nil:
+	case io.EOF, io.ErrUnexpectedEOF:
+		// A partial block is only valid at the end of the stream and
+		// only if there are no predicted bytes beyond its length
+		if length = r.blockLength(flags, r.input[1:1+count]); length == 0 {
+			return io.ErrUnexpectedEOF
+		}
+		err = io.EOF
+	default:
+		return err
 	}
 
-	return total, err
+	r.from, r.to = 0, len(r.decode(r.buffer[:0], flags, r.input[1:1+count], length))
+	return err
+}
+
+// Returns the guess table and the buffer over the source to the buffer pool
+func (r *Reader) release() {
+	r.context.release()
+	r.source.release()
+}
+
+// Discards the Reader's state and makes it equivalent to
+// a new Reader with the same options over the provided io.Reader.
+func (r *Reader) Reset(reader io.Reader) {
+	r.context.reset()
+	r.source.reset(reader)
+	r.err, r.from, r.to = nil, 0, 0
 }
@@ -1,11 +1,17 @@
 package predictor // import "github.com/spaskalev/misc/predictor"
 
 import (
+	"bufio"
 	"bytes"
+	"errors"
 	"fmt"
 	diff "github.com/spaskalev/diff"
+	iou "github.com/spaskalev/misc/ioutil"
+	iot "github.com/spaskalev/misc/ioutil/iotest"
+	"io"
 	"io/ioutil"
 	"testing"
+	"testing/iotest"
 )
 
 // Sample input from RFC1978 - PPP Predictor Compression Protocol
@@ -24,6 +30,13 @@ var output = []byte{0x60, 0x41, 0x41, 0x41, 0x41, 0x41, 0x0a, 0x60,
 	0x41, 0x42, 0x0a, 0x60, 0x42, 0x41, 0x42, 0x41,
 	0x42, 0x0a, 0x60, 0x78, 0x78, 0x78, 0x78, 0x78, 0x0a}
 
+// The sample as a type 1 packet, compressed with the FCS over its length and input.
+// RFC1978 has no packet samples, so the packet vectors are this package's own output.
+var packet1 = append(append([]byte{0x80, 0x38}, output...), 0x89, 0x50)
+
+// The sample as a type 2 packet, with this package's 8-bit length framing
+var packet2 = append(append([]byte{0x38}, output...), 0x53, 0x37)
+
 func TestCompressorSample(t *testing.T) {
 	var (
 		buf bytes.Buffer
@@ -49,6 +62,132 @@ func TestCompressorSample(t *testing.T) {
 	}
 }
 
+func TestWriterSample(t *testing.T) {
+	var (
+		buf bytes.Buffer
+		out *Writer = NewWriter(&buf)
+	)
+
+	// Write through a bufio.Writer, which never issues a nil write
+	bw := bufio.NewWriterSize(out, 16)
+	if _, err := bw.Write(input); err != nil {
+		t.Error(err)
+	}
+	if err := bw.Flush(); err != nil {
+		t.Error(err)
+	}
+	if err := out.Close(); err != nil {
+		t.Error(err)
+	}
+
+	if !bytes.Equal(buf.Bytes(), output) {
+		t.Errorf("Unexpected compressed output %#x", buf.Bytes())
+	}
+
+	if _, err := out.Write(input); err != ErrClosed {
+		t.Error("Unexpected error while writing to a closed writer", err)
+	}
+	if err := out.Close(); err != nil {
+		t.Error("Unexpected error while closing a closed writer", err)
+	}
+
+	// A reset writer should produce the same output
+	buf.Reset()
+	out.Reset(&buf)
+	if _, err := io.MultiWriter(out).Write(input); err != nil {
+		t.Error(err)
+	}
+	if err := out.Flush(); err != nil {
+		t.Error(err)
+	}
+	if !bytes.Equal(buf.Bytes(), output) {
+		t.Errorf("Unexpected compressed output after reset %#x", buf.Bytes())
+	}
+}
+
+func TestWriterError(t *testing.T) {
+	var (
+		fail error   = errors.New("Invalid write")
+		out  *Writer = NewWriter(iou.WriterFunc(func([]byte) (int, error) {
+			return 0, fail
+		}))
+	)
+
+	if _, err := out.Write(input[:4]); err != nil {
+		t.Error("Unexpected error while buffering", err)
+	}
+	if _, err := out.Write(input[4:]); err != fail {
+		t.Error("Unexpected error", err)
+	}
+	if err := out.Flush(); err != fail {
+		t.Error("Error is not sticky", err)
+	}
+	if err := out.Close(); err != fail {
+		t.Error("Error is not sticky", err)
+	}
+}
+
+func TestWriterFlush(t *testing.T) {
+	var (
+		buf bytes.Buffer
+		out *Writer = NewWriter(&buf)
+	)
+
+	// A flush at a block boundary does not end the stream
+	out.Write(input[:16])
+	if err := out.Flush(); err != nil {
+		t.Error("Unexpected error from Flush", err)
+	}
+	out.Write(input[16:20])
+	if err := out.Flush(); err != nil {
+		t.Error("Unexpected error from Flush", err)
+	}
+
+	// A flush of a partial block does
+	if count, err := out.Write(input[20:]); count != 0 || err != ErrFlushed {
+		t.Error("Unexpected write after a partial flush", count, err)
+	}
+	if err := out.Close(); err != nil {
+		t.Error("Unexpected error from Close", err)
+	}
+	if result, err := ioutil.ReadAll(NewReader(&buf)); err != nil || !bytes.Equal(result, input[:20]) {
+		t.Errorf("Unexpected result %q %v", result, err)
+	}
+
+	// Until the writer is reset
+	buf.Reset()
+	out.Reset(&buf)
+	if _, err := out.Write(input); err != nil {
+		t.Error("Unexpected error after reset", err)
+	}
+}
+
+func TestWriterRelease(t *testing.T) {
+	var buf bytes.Buffer
+
+	w := NewWriter(&buf)
+	w.Write(input)
+	w.Close()
+	if w.table != nil {
+		t.Error("The guess table is not returned to the pool on Close")
+	}
+
+	// A reset writer draws a clean table
+	buf.Reset()
+	w.Reset(&buf)
+	w.Write(input)
+	w.Close()
+	if !bytes.Equal(buf.Bytes(), output) {
+		t.Errorf("Unexpected compressed output after reset %#x", buf.Bytes())
+	}
+
+	r := NewReader(bytes.NewReader(output))
+	ioutil.ReadAll(r)
+	if r.table != nil {
+		t.Error("The guess table is not returned to the pool at the end")
+	}
+}
+
 func TestDecompressorSample(t *testing.T) {
 	in := Decompressor(bytes.NewReader(output))
 	result, err := ioutil.ReadAll(in)
@@ -64,6 +203,271 @@ func TestDecompressorSample(t *testing.T) {
 	}
 }
 
+// Reads the expected data from the reader in reads of varying sizes and checks
+// the end of the stream, and the reads after seeking if the reader is an io.Seeker.
+// It covers the same ground as iotest.TestReader, which needs Go 1.16.
+func checkReader(reader io.Reader, expected []byte) error {
+	var (
+		result []byte
+		empty  int
+	)
+	for size := 1; ; size = size%13 + 1 {
+		buf := make([]byte, size)
+		count, err := reader.Read(buf)
+		result = append(result, buf[:count]...)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if count == 0 {
+			if empty++; empty > 100 {
+				return io.ErrNoProgress
+			}
+		}
+	}
+	if !bytes.Equal(result, expected) {
+		return fmt.Errorf("unexpected result of length %d", len(result))
+	}
+	if count, err := reader.Read(make([]byte, 1)); count != 0 || err != io.EOF {
+		return fmt.Errorf("unexpected read at the end: %d, %v", count, err)
+	}
+
+	seeker, ok := reader.(io.Seeker)
+	if !ok || len(expected) == 0 {
+		return nil
+	}
+	for _, c := range []struct {
+		offset int64
+		whence int
+		at     int64
+	}{
+		{int64(len(expected) / 2), io.SeekStart, int64(len(expected) / 2)},
+		{0, io.SeekStart, 0},
+		{-1, io.SeekEnd, int64(len(expected) - 1)},
+		{int64(-len(expected) / 2), io.SeekCurrent, int64(len(expected) - len(expected)/2)},
+	} {
+		at, err := seeker.Seek(c.offset, c.whence)
+		if err != nil || at != c.at {
+			return fmt.Errorf("unexpected seek to %d: %d, %v", c.at, at, err)
+		}
+		buf := make([]byte, 10)
+		if rest := int64(len(expected)) - at; rest < int64(len(buf)) {
+			buf = buf[:rest]
+		}
+		if _, err := io.ReadFull(reader, buf); err != nil || !bytes.Equal(buf, expected[at:at+int64(len(buf))]) {
+			return fmt.Errorf("unexpected read after a seek to %d: %v", at, err)
+		}
+	}
+	return nil
+}
+
+func TestReaderContract(t *testing.T) {
+	if err := checkReader(NewReader(bytes.NewReader(output)), input); err != nil {
+		t.Error(err)
+	}
+	if err := checkReader(NewReader(iotest.OneByteReader(bytes.NewReader(output))), input); err != nil {
+		t.Error(err)
+	}
+	if err := checkReader(NewReader(iotest.DataErrReader(bytes.NewReader(output))), input); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestReaderTruncated(t *testing.T) {
+	// A stream cut at a block boundary is a valid shorter stream
+	result, err := ioutil.ReadAll(NewReader(bytes.NewReader(output[:14])))
+	if err != nil || !bytes.Equal(result, input[:16]) {
+		t.Error("Unexpected result for a stream cut at a block boundary", result, err)
+	}
+
+	// A header with predicted bytes beyond the available data is truncated
+	result, err = ioutil.ReadAll(NewReader(bytes.NewReader(output[:15])))
+	if err != io.ErrUnexpectedEOF || !bytes.Equal(result, input[:16]) {
+		t.Error("Unexpected result for a truncated block", result, err)
+	}
+
+	// A header with no data that follows is truncated
+	result, err = ioutil.ReadAll(NewReader(bytes.NewReader([]byte{0})))
+	if err != io.ErrUnexpectedEOF || len(result) != 0 {
+		t.Error("Unexpected result for a header-only stream", result, err)
+	}
+
+	// Every prefix of the sample either decompresses to a prefix of the input or fails
+	for i := 0; i < len(output); i++ {
+		result, err := ioutil.ReadAll(NewReader(bytes.NewReader(output[:i])))
+		if err != nil && err != io.ErrUnexpectedEOF {
+			t.Error("Unexpected error for prefix", i, err)
+		}
+		if !bytes.HasPrefix(input, result) {
+			t.Error("Unexpected result for prefix", i, result)
+		}
+	}
+}
+
+func TestReaderError(t *testing.T) {
+	var (
+		fail error   = errors.New("Invalid read")
+		in   *Reader = NewReader(io.MultiReader(bytes.NewReader(output[:14]), iou.ReaderFunc(func([]byte) (int, error) { return 0, fail })))
+	)
+
+	result, err := ioutil.ReadAll(in)
+	if err != fail || !bytes.Equal(result, input[:16]) {
+		t.Error("Unexpected result", result, err)
+	}
+
+	// The error should be sticky
+	if count, err := in.Read(make([]byte, 8)); count != 0 || err != fail {
+		t.Error("Unexpected result after an error", count, err)
+	}
+
+	// A reset reader should work normally
+	in.Reset(bytes.NewReader(output))
+	result, err = ioutil.ReadAll(in)
+	if err != nil || !bytes.Equal(result, input) {
+		t.Error("Unexpected result after reset", result, err)
+	}
+}
+
+// Reads everything from the reader, which may only fail with the timeout
+// of the test reader, and checks that the result is a prefix of the expected data
+func readWrapped(reader io.Reader, expected []byte) error {
+	result, err := ioutil.ReadAll(reader)
+	if err != nil && err != iotest.ErrTimeout {
+		return err
+	}
+	if !bytes.HasPrefix(expected, result) || (err == nil && len(result) != len(expected)) {
+		return fmt.Errorf("unexpected result of length %d", len(result))
+	}
+	return nil
+}
+
+// Returns a reader of the data that fails the test if it is read past the data
+func stalling(t *testing.T, data []byte) io.Reader {
+	return io.MultiReader(bytes.NewReader(data), iou.ReaderFunc(func([]byte) (int, error) {
+		t.Error("Read waits for more data")
+		return 0, io.EOF
+	}))
+}
+
+func TestReaderAvailable(t *testing.T) {
+	var (
+		r      *Reader = NewReader(stalling(t, output[:len(output)-1]))
+		result []byte  = make([]byte, 1024)
+	)
+
+	if count, err := r.Read(result); count == 0 || err != nil || !bytes.Equal(result[:count], input[:count]) {
+		t.Error("Unexpected read", count, err)
+	}
+
+	// Buffered blocks are decompressed in the same call
+	var (
+		data []byte = textCorpus(1 << 12)
+		buf  bytes.Buffer
+	)
+	w := NewWriter(&buf)
+	w.Write(data)
+	w.Close()
+
+	r = NewReader(&buf)
+	if count, err := r.Read(result); count != len(result) || err != nil || !bytes.Equal(result, data[:count]) {
+		t.Error("Unexpected read of buffered blocks", count, err)
+	}
+}
+
+func TestReaderWrappers(t *testing.T) {
+	var (
+		data   []byte = textCorpus(1 << 14)
+		framed []byte
+	)
+
+	framed, err := frame(data, len(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, wrapper := range iot.Readers {
+		if err := readWrapped(NewReader(wrapper.Wrap(bytes.NewReader(output))), input); err != nil {
+			t.Error(wrapper.Name, err)
+		}
+
+		r, err := NewFrameReader(wrapper.Wrap(bytes.NewReader(framed)))
+		if err == iotest.ErrTimeout {
+			continue
+		}
+		if err != nil {
+			t.Fatal(wrapper.Name, err)
+		}
+		if err := readWrapped(r, data); err != nil {
+			t.Error(wrapper.Name, "framed", err)
+		}
+	}
+}
+
+func TestWriterFaults(t *testing.T) {
+	var fail error = errors.New("Invalid write")
+
+	for limit := 0; limit < len(output); limit++ {
+		var (
+			buf bytes.Buffer
+			w   *Writer = NewWriter(iot.ErrorWriter(&buf, limit, fail))
+			err error
+		)
+
+		if _, err = w.Write(input); err == nil {
+			err = w.Close()
+		}
+		if err != fail {
+			t.Error("Unexpected error for limit", limit, err)
+		}
+		if !bytes.Equal(buf.Bytes(), output[:limit]) {
+			t.Errorf("Unexpected output for limit %d: %#x", limit, buf.Bytes())
+		}
+	}
+
+	// Short writes are reported as such
+	var buf bytes.Buffer
+	w := NewWriter(iot.ShortWriter(&buf, 3))
+	if _, err := w.Write(input); err != io.ErrShortWrite {
+		t.Error("Unexpected error for a short write", err)
+	}
+}
+
+func TestFrameWriterFaults(t *testing.T) {
+	var fail error = errors.New("Invalid write")
+
+	framed, err := frame(input, len(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for limit := 0; limit < len(framed); limit++ {
+		var buf bytes.Buffer
+
+		w := NewFrameWriter(iot.ErrorWriter(&buf, limit, fail))
+		if _, err = w.Write(input); err == nil {
+			err = w.Close()
+		}
+		if err != fail {
+			t.Error("Unexpected error for limit", limit, err)
+		}
+		if !bytes.Equal(buf.Bytes(), framed[:limit]) {
+			t.Errorf("Unexpected output for limit %d: %#x", limit, buf.Bytes())
+		}
+	}
+
+	// Short writes are reported as such
+	var buf bytes.Buffer
+	w := NewFrameWriter(iot.ShortWriter(&buf, 3))
+	if _, err = w.Write(input); err == nil {
+		err = w.Close()
+	}
+	if err != io.ErrShortWrite {
+		t.Error("Unexpected error for a short write", err)
+	}
+}
+
 var testData = [][]byte{
 	[]byte{},
 	[]byte{0, 1, 2, 3},
@@ -72,25 +476,59 @@ var testData = [][]byte{
 	[]byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15},
 }
 
+// Compressor constructors under test, returning a writer and its flush function
+var compressors = map[string]func(io.Writer) (io.Writer, func() error){
+	"Compressor": func(w io.Writer) (io.Writer, func() error) {
+		c := Compressor(w)
+		return c, func() error {
+			_, err := c.Write(nil)
+			return err
+		}
+	},
+	"Writer": func(w io.Writer) (io.Writer, func() error) {
+		c := NewWriter(w)
+		return c, c.Close
+	},
+}
+
 func TestCycle(t *testing.T) {
-	for i := 0; i < len(testData); i++ {
-		if err := cycle(testData[i], len(testData[i])); err != nil {
-			t.Error(err)
+	for name, compressor := range compressors {
+		for i := 0; i < len(testData); i++ {
+			if err := cycle(testData[i], len(testData[i]), compressor); err != nil {
+				t.Error(name, err)
+			}
 		}
 	}
 }
 
 func TestStepCycle(t *testing.T) {
-	for i := 0; i < len(testData); i++ {
-		for j := 1; j < len(testData[i]); j++ {
-			if err := cycle(testData[i], j); err != nil {
-				t.Error("Error for testData[", i, "], step[", j, "] ", err)
+	for name, compressor := range compressors {
+		for i := 0; i < len(testData); i++ {
+			for j := 1; j < len(testData[i]); j++ {
+				if err := cycle(testData[i], j, compressor); err != nil {
+					t.Error(name, "error for testData[", i, "], step[", j, "] ", err)
+				}
 			}
 		}
 	}
 }
 
-func cycle(input []byte, step int) error {
+func TestLargeCycle(t *testing.T) {
+	var input []byte = make([]byte, writeBlocks*8*3+5)
+	for i := range input {
+		input[i] = byte(i * i >> 3)
+	}
+
+	for name, compressor := range compressors {
+		for _, step := range []int{7, 4099, len(input)} {
+			if err := cycle(input, step, compressor); err != nil {
+				t.Error(name, "step", step, err)
+			}
+		}
+	}
+}
+
+func cycle(input []byte, step int, newCompressor func(io.Writer) (io.Writer, func() error)) error {
 	var (
 		buf bytes.Buffer
 		err error
@@ -101,7 +539,7 @@ func cycle(input []byte, step int) error {
 	}
 
 	// Create a compressor and write the given data
-	compressor := Compressor(&buf)
+	compressor, flush := newCompressor(&buf)
 
 	var data []byte = input
 	var trace []byte = make([]byte, 0)
@@ -123,7 +561,7 @@ func cycle(input []byte, step int) error {
 	}
 
 	// Flush the compressor
-	_, err = compressor.Write(nil)
+	err = flush()
 	if err != nil {
 		return err
 	}
@@ -135,6 +573,10 @@ func cycle(input []byte, step int) error {
 		return err
 	}
 
+	if bytes.Equal(input, decompressed) {
+		return nil
+	}
+
 	// Diff the result against the initial input
 	delta := diff.Diff(diff.WithEqual(len(input), len(decompressed),
 		func(i, j int) bool { return input[i] == decompressed[j] }))
@@ -0,0 +1,262 @@
+package predictor // import "github.com/spaskalev/misc/predictor"
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"sync"
+)
+
+// The size of the index length and magic at the end of a seekable stream
+const footerSize = 4 + 4
+
+// ErrIndex is returned when the chunk index of a seekable stream is missing or invalid
+var ErrIndex = errors.New("predictor: invalid chunk index")
+
+// Appends the chunk index and its footer to the stream
+func (w *FrameWriter) writeIndex() error {
+	var footer [footerSize]byte
+	binary.LittleEndian.PutUint32(footer[:], uint32(len(w.index)))
+	copy(footer[4:], magic[:])
+
+	if err := w.write(w.index); err != nil {
+		return err
+	}
+	return w.write(footer[:])
+}
+
+// The location of a chunk in the framed and in the plain data
+type chunkEntry struct {
+	offset, size int64
+	from, to     int64
+}
+
+// A SeekableReader provides random access to the plain data of
+// a seekable framed stream, decompressing only the chunks that
+// overlap the requested range.
+//
+// The checksum of the plain data is not verified.
+// ReadAt is safe for concurrent use, while Read and Seek are not.
+type SeekableReader struct {
+	source io.ReaderAt
+	index  []chunkEntry
+	length int64
+
+	// The position for Read and Seek
+	position int64
+
+	// The last decompressed chunk
+	mutex  sync.Mutex
+	cache  chunk
+	cached int
+}
+
+// Returns a new SeekableReader over a seekable framed stream of the given size.
+// The header and the chunk index are read and verified before returning.
+func NewSeekableReader(source io.ReaderAt, size int64) (*SeekableReader, error) {
+	return NewSeekableReaderOptions(source, size, Options{})
+}
+
+// Returns a new SeekableReader like NewSeekableReader that uses the dictionary
+// from the given options if the stream requires it.
+func NewSeekableReaderOptions(source io.ReaderAt, size int64, opts Options) (*SeekableReader, error) {
+	if err := opts.readerOptions().check(); err != nil {
+		return nil, err
+	}
+
+	h, err := readHeader(io.NewSectionReader(source, 0, size), opts.Dictionary)
+	if err != nil {
+		return nil, err
+	}
+	if h.flags&flagSeekable == 0 {
+		return nil, ErrIndex
+	}
+
+	// Read the footer, the index and the trailer that precedes it
+	var footer [footerSize]byte
+	if size < int64(h.size())+1+trailerSize+footerSize {
+		return nil, ErrIndex
+	}
+	if err = readAt(source, footer[:], size-footerSize); err != nil {
+		return nil, err
+	}
+	if [len(magic)]byte{footer[4], footer[5], footer[6], footer[7]} != magic {
+		return nil, ErrIndex
+	}
+
+	// The index size is checked against the stream size before allocating for it
+	var (
+		indexSize int64 = int64(binary.LittleEndian.Uint32(footer[:]))
+		end       int64 = size - footerSize - indexSize - trailerSize - 1
+	)
+	if end < int64(h.size()) {
+		return nil, ErrIndex
+	}
+	buf := make([]byte, 1+trailerSize+indexSize)
+	if err = readAt(source, buf, end); err != nil {
+		return nil, err
+	}
+
+	var r SeekableReader
+	r.source, r.cached = source, -1
+	r.cache.init(h.opts)
+
+	// Walk the index, accounting for the chunk locations
+	var offset int64 = int64(h.size())
+	for entries := buf[1+trailerSize:]; len(entries) > 0; {
+		length, count := binary.Uvarint(entries)
+		if count <= 0 || length == 0 || length > maxFrameSize {
+			return nil, ErrIndex
+		}
+		entries = entries[count:]
+
+		framed, count := binary.Uvarint(entries)
+		if count <= 0 || framed > uint64(end-offset) {
+			return nil, ErrIndex
+		}
+		entries = entries[count:]
+
+		r.index = append(r.index, chunkEntry{offset, int64(framed), r.length, r.length + int64(length)})
+		offset, r.length = offset+int64(framed), r.length+int64(length)
+	}
+
+	// The chunks must be followed by the end marker and a matching trailer
+	if offset != end || buf[0] != 0 || binary.LittleEndian.Uint64(buf[1:]) != uint64(r.length) {
+		return nil, ErrIndex
+	}
+	return &r, nil
+}
+
+// Returns the length of the plain data
+func (r *SeekableReader) Size() int64 {
+	return r.length
+}
+
+// Implements io.ReaderAt
+func (r *SeekableReader) ReadAt(output []byte, offset int64) (int, error) {
+	if offset < 0 {
+		return 0, errors.New("predictor: negative offset")
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	var total int
+	for len(output) > 0 && offset < r.length {
+		// Find the chunk that contains the offset
+		at := r.find(offset)
+		if err := r.load(at); err != nil {
+			return total, err
+		}
+
+		count := copy(output, r.cache.plain[offset-r.index[at].from:])
+		output, offset, total = output[count:], offset+int64(count), total+count
+	}
+
+	if len(output) > 0 {
+		return total, io.EOF
+	}
+	return total, nil
+}
+
+// Returns the index of the chunk that contains the plain data offset
+func (r *SeekableReader) find(offset int64) int {
+	low, high := 0, len(r.index)-1
+	for low < high {
+		middle := (low + high) / 2
+		if r.index[middle].to <= offset {
+			low = middle + 1
+		} else {
+			high = middle
+		}
+	}
+	return low
+}
+
+// Reads and decompresses a chunk into the cache
+func (r *SeekableReader) load(at int) error {
+	if r.cached == at {
+		return nil
+	}
+	r.cached = -1
+
+	var entry chunkEntry = r.index[at]
+	if int64(cap(r.cache.packed)) < entry.size {
+		r.cache.packed = make([]byte, entry.size)
+	}
+	framed := r.cache.packed[:entry.size]
+	if err := readAt(r.source, framed, entry.offset); err != nil {
+		return err
+	}
+
+	// Skip over the frame's lengths
+	length, count := binary.Uvarint(framed)
+	if count <= 0 || int64(length) != entry.to-entry.from {
+		return CorruptInputError(entry.offset)
+	}
+	size, skip := binary.Uvarint(framed[count:])
+	stored := size == 0
+	if stored {
+		size = length
+	}
+	if skip <= 0 || uint64(len(framed)-count-skip) != size {
+		return CorruptInputError(entry.offset)
+	}
+
+	// Stored chunks are copied as the compressed data buffer is reused
+	if stored {
+		r.cache.plain, r.cache.err = append(r.cache.plain[:0], framed[count+skip:]...), nil
+	} else {
+		r.cache.packed = framed[count+skip:]
+		r.cache.decompress()
+		r.cache.packed = framed
+	}
+	if r.cache.err != nil || int64(len(r.cache.plain)) != entry.to-entry.from {
+		return CorruptInputError(entry.offset + int64(count+skip))
+	}
+
+	r.cached = at
+	return nil
+}
+
+// Implements io.Reader
+func (r *SeekableReader) Read(output []byte) (int, error) {
+	count, err := r.ReadAt(output, r.position)
+	r.position += int64(count)
+	if err == io.EOF && count > 0 {
+		err = nil
+	}
+	return count, err
+}
+
+// Implements io.Seeker
+func (r *SeekableReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.position
+	case io.SeekEnd:
+		offset += r.length
+	default:
+		return r.position, errors.New("predictor: invalid whence")
+	}
+
+	if offset < 0 {
+		return r.position, errors.New("predictor: negative position")
+	}
+	r.position = offset
+	return offset, nil
+}
+
+// Reads exactly len(output) bytes at the given offset. An io.ReaderAt may return
+// io.EOF along with a full read at the end of its data, which is not an error here.
+func readAt(source io.ReaderAt, output []byte, offset int64) error {
+	count, err := source.ReadAt(output, offset)
+	if count == len(output) {
+		return nil
+	}
+	if err == nil {
+		return io.ErrUnexpectedEOF
+	}
+	return unexpected(err)
+}
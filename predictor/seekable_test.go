@@ -0,0 +1,188 @@
+package predictor // import "github.com/spaskalev/misc/predictor"
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"runtime"
+	"testing"
+)
+
+// Counts the calls to ReadAt of the underlying io.ReaderAt
+type countingReaderAt struct {
+	io.ReaderAt
+	calls int
+}
+
+func (c *countingReaderAt) ReadAt(output []byte, offset int64) (int, error) {
+	c.calls++
+	return c.ReaderAt.ReadAt(output, offset)
+}
+
+// Returns io.EOF with reads that reach the end of the data, as io.ReaderAt permits
+type eofReaderAt []byte
+
+func (e eofReaderAt) ReadAt(output []byte, offset int64) (int, error) {
+	count, err := bytes.NewReader(e).ReadAt(output, offset)
+	if err == nil && offset+int64(count) == int64(len(e)) {
+		err = io.EOF
+	}
+	return count, err
+}
+
+func TestSeekable(t *testing.T) {
+	var data []byte = textCorpus(1<<14 + 5)
+
+	framed, err := chunked(data, Options{ChunkSize: 1000, Seekable: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := NewSeekableReader(bytes.NewReader(framed), int64(len(framed)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Size() != int64(len(data)) {
+		t.Error("Unexpected size", r.Size())
+	}
+
+	// Covers Read, ReadAt and Seek
+	if err := checkReader(r, data); err != nil {
+		t.Error(err)
+	}
+
+	// Ranges within, across and beyond chunks
+	for _, c := range []struct{ offset, length int }{{0, 1}, {999, 2}, {1500, 3000}, {len(data) - 3, 3}, {len(data) - 3, 10}} {
+		var (
+			result   []byte = make([]byte, c.length)
+			expected []byte = data[c.offset:]
+		)
+		if len(expected) > c.length {
+			expected = expected[:c.length]
+		}
+
+		count, err := r.ReadAt(result, int64(c.offset))
+		if !bytes.Equal(result[:count], expected) {
+			t.Error("Unexpected result at", c.offset, "for", c.length)
+		}
+		if (count < c.length) != (err == io.EOF) {
+			t.Error("Unexpected error at", c.offset, "for", c.length, err)
+		}
+	}
+
+	// Full reads at the end of the source may come with io.EOF
+	er, err := NewSeekableReader(eofReaderAt(framed), int64(len(framed)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result, err := ioutil.ReadAll(io.NewSectionReader(er, 0, er.Size())); err != nil || !bytes.Equal(result, data) {
+		t.Error("Unexpected result for a source that returns io.EOF", err)
+	}
+
+	// The stream is still readable sequentially
+	fr, err := NewFrameReader(bytes.NewReader(framed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result, err := ioutil.ReadAll(fr); err != nil || !bytes.Equal(result, data) {
+		t.Error("Unexpected sequential result", err)
+	}
+}
+
+func TestSeekableOverlapping(t *testing.T) {
+	var data []byte = textCorpus(1 << 14)
+
+	framed, err := chunked(data, Options{ChunkSize: 1000, Seekable: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	source := &countingReaderAt{ReaderAt: bytes.NewReader(framed)}
+	r, err := NewSeekableReader(source, int64(len(framed)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Reading within a single chunk reads only that chunk, once
+	source.calls = 0
+	result := make([]byte, 10)
+	r.ReadAt(result, 5010)
+	r.ReadAt(result, 5020)
+	if source.calls != 1 || !bytes.Equal(result, data[5020:5030]) {
+		t.Error("Unexpected reads for a single chunk", source.calls)
+	}
+
+	// Reading across two chunks reads both
+	source.calls = 0
+	result = make([]byte, 100)
+	r.ReadAt(result, 7950)
+	if source.calls != 2 || !bytes.Equal(result, data[7950:8050]) {
+		t.Error("Unexpected reads across chunks", source.calls)
+	}
+}
+
+func TestSeekableErrors(t *testing.T) {
+	var data []byte = textCorpus(1 << 12)
+
+	if _, err := NewFrameWriterOptions(ioutil.Discard, Options{Seekable: true}); err != ErrOptions {
+		t.Error("Unexpected error for a seekable stream without chunks", err)
+	}
+
+	// A stream without an index
+	framed, err := chunked(data, Options{ChunkSize: 1000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewSeekableReader(bytes.NewReader(framed), int64(len(framed))); err != ErrIndex {
+		t.Error("Unexpected error for a stream without an index", err)
+	}
+
+	framed, err = chunked(data, Options{ChunkSize: 1000, Seekable: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Returns a copy of the stream with a modified byte
+	modify := func(at int, delta byte) []byte {
+		result := append([]byte{}, framed...)
+		result[at] += delta
+		return result
+	}
+
+	for name, modified := range map[string][]byte{
+		"truncated":    framed[:len(framed)-1],
+		"magic":        modify(len(framed)-1, 1),
+		"index length": modify(len(framed)-footerSize, 1),
+		"index entry":  modify(len(framed)-footerSize-1, 1),
+		"trailer":      modify(len(framed)-footerSize-int(framed[len(framed)-footerSize])-trailerSize, 1),
+	} {
+		if _, err := NewSeekableReader(bytes.NewReader(modified), int64(len(modified))); err != ErrIndex {
+			t.Error("Unexpected error for", name, err)
+		}
+	}
+
+	// An index size beyond the stream size is rejected before allocating for it
+	huge := append([]byte{}, framed...)
+	copy(huge[len(huge)-footerSize:], []byte{0xff, 0xff, 0xff, 0xff})
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	if _, err := NewSeekableReader(bytes.NewReader(huge), int64(len(huge))); err != ErrIndex {
+		t.Error("Unexpected error for a huge index size", err)
+	}
+	runtime.ReadMemStats(&after)
+	if allocated := after.TotalAlloc - before.TotalAlloc; allocated > 1<<20 {
+		t.Error("Unexpected allocation for a huge index size", allocated)
+	}
+
+	// A chunk with corrupt data
+	r, err := NewSeekableReader(bytes.NewReader(modify(headerSize, 1)), int64(len(framed)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.ReadAt(make([]byte, 10), 0); err != CorruptInputError(headerSize) {
+		t.Error("Unexpected error for a corrupt chunk", err)
+	}
+	if _, err := r.ReadAt(make([]byte, 10), 1000); err != nil {
+		t.Error("Unexpected error for a valid chunk", err)
+	}
+}
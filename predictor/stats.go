@@ -0,0 +1,79 @@
+package predictor // import "github.com/spaskalev/misc/predictor"
+
+// Stats contains the counters of a compressor
+type Stats struct {
+	// Plain bytes written to the compressor and compressed bytes
+	// written to the underlying writer, including any framing
+	In, Out uint64
+
+	// Compressed blocks and their correctly and incorrectly predicted bytes
+	Blocks, Hits, Misses uint64
+
+	// Guess table entries holding a non-zero guess and the table size
+	Occupied, TableSize int
+}
+
+// Returns the ratio of compressed to plain bytes
+func (s Stats) Ratio() float64 {
+	if s.In == 0 {
+		return 0
+	}
+	return float64(s.Out) / float64(s.In)
+}
+
+// Returns the ratio of predicted bytes to all compressed bytes
+func (s Stats) HitRate() float64 {
+	if s.Hits+s.Misses == 0 {
+		return 0
+	}
+	return float64(s.Hits) / float64(s.Hits+s.Misses)
+}
+
+// Counters that are updated by the context while compressing
+type counters struct {
+	blocks, hits, misses uint64
+	occupied             int
+}
+
+// Accounts for a compressed block of the given length and predicted bytes
+func (c *counters) block(hits int, length int) {
+	c.blocks, c.hits, c.misses = c.blocks+1, c.hits+uint64(hits), c.misses+uint64(length-hits)
+}
+
+// Accounts for a guess table entry that changes from old to new
+func (c *counters) guess(old, new byte) {
+	switch {
+	case old == 0 && new != 0:
+		c.occupied++
+	case old != 0 && new == 0:
+		c.occupied--
+	}
+}
+
+// Adds the block counters of another context, keeping its occupancy
+func (c *counters) add(other counters) {
+	c.blocks, c.hits, c.misses = c.blocks+other.blocks, c.hits+other.hits, c.misses+other.misses
+	c.occupied = other.occupied
+}
+
+// Returns the context's counters with the given byte counts and table size
+func (ctx *context) stats(in, out uint64, tableSize int) Stats {
+	return Stats{
+		In: in, Out: out,
+		Blocks: ctx.counters.blocks, Hits: ctx.counters.hits, Misses: ctx.counters.misses,
+		Occupied: ctx.counters.occupied, TableSize: tableSize,
+	}
+}
+
+// Returns the Writer's counters, which are cleared by Reset
+func (w *Writer) Stats() Stats {
+	return w.stats(w.counters.hits+w.counters.misses+uint64(w.length), w.out, w.size)
+}
+
+// Returns the FrameWriter's counters, which are cleared by Reset.
+//
+// In chunked mode the block counters of a chunk are added when it is written
+// and the occupancy is that of the last written chunk's guess table.
+func (w *FrameWriter) Stats() Stats {
+	return w.stats(w.length+uint64(len(w.frame)), w.out, w.header.opts.tableSize())
+}
@@ -0,0 +1,75 @@
+package predictor // import "github.com/spaskalev/misc/predictor"
+
+import (
+	"bytes"
+	"testing"
+)
+
+// Returns the number of non-zero guess table entries
+func occupied(table []byte) (count int) {
+	for _, guess := range table {
+		if guess != 0 {
+			count++
+		}
+	}
+	return count
+}
+
+func TestWriterStats(t *testing.T) {
+	var buf bytes.Buffer
+
+	w := NewWriter(&buf)
+	w.Write(input[:13])
+	if stats := w.Stats(); stats.In != 13 || stats.Blocks != 1 || stats.Out != uint64(buf.Len()) {
+		t.Errorf("Unexpected stats before closing %+v", stats)
+	}
+	w.Write(input[13:])
+	table := occupied(w.table)
+	w.Close()
+
+	stats := w.Stats()
+	if stats.In != uint64(len(input)) || stats.Out != uint64(len(output)) {
+		t.Errorf("Unexpected byte counts %+v", stats)
+	}
+	// Every block has a header followed by its mispredicted bytes
+	if stats.Blocks != uint64(len(input)+7)/8 || stats.Blocks+stats.Misses != stats.Out {
+		t.Errorf("Unexpected block counts %+v", stats)
+	}
+	if stats.Hits+stats.Misses != stats.In || stats.Hits != uint64(len(input)-len(output))+stats.Blocks {
+		t.Errorf("Unexpected prediction counts %+v", stats)
+	}
+	if stats.Occupied != table || stats.TableSize != 1<<defaultTableBits {
+		t.Errorf("Unexpected table counts %+v", stats)
+	}
+
+	w.Reset(&buf)
+	if stats := w.Stats(); stats != (Stats{TableSize: 1 << defaultTableBits}) {
+		t.Errorf("Unexpected stats after reset %+v", stats)
+	}
+}
+
+func TestFrameWriterStats(t *testing.T) {
+	var data []byte = textCorpus(1 << 14)
+
+	for _, opts := range []Options{{}, {ChunkSize: 1000, TableBits: 12}} {
+		var buf bytes.Buffer
+
+		w, err := NewFrameWriterOptions(&buf, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(data)
+		w.Close()
+
+		stats := w.Stats()
+		if stats.In != uint64(len(data)) || stats.Out != uint64(buf.Len()) {
+			t.Errorf("Unexpected byte counts for %+v: %+v", opts, stats)
+		}
+		if stats.Hits+stats.Misses != stats.In || stats.Ratio() >= 1 || stats.HitRate() <= 0 {
+			t.Errorf("Unexpected prediction counts for %+v: %+v", opts, stats)
+		}
+		if stats.Occupied == 0 || stats.TableSize != 1<<opts.tableBits() {
+			t.Errorf("Unexpected table counts for %+v: %+v", opts, stats)
+		}
+	}
+}
@@ -0,0 +1,28 @@
+package predictor // import "github.com/spaskalev/misc/predictor"
+
+import (
+	iou "github.com/spaskalev/misc/ioutil"
+	"io"
+)
+
+// Returns a Transform that compresses data to the framed format with the given options
+func CompressTransform(opts Options) iou.Transform {
+	return iou.WriterTransform(func(writer io.Writer) (io.WriteCloser, error) {
+		w, err := NewFrameWriterOptions(writer, opts)
+		if err != nil {
+			return nil, err
+		}
+		return w, nil
+	})
+}
+
+// Returns a Transform that decompresses data from the framed format with the given options
+func DecompressTransform(opts Options) iou.Transform {
+	return iou.ReaderTransform(func(reader io.Reader) (io.Reader, error) {
+		r, err := NewFrameReaderOptions(reader, opts)
+		if err != nil {
+			return nil, err
+		}
+		return r, nil
+	})
+}